@@ -0,0 +1,251 @@
+// Copyright 2019 Nirenjan Krishnan. All rights reserved.
+
+package vanity
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AccessEntry contains the details of a single request handled by the
+// server, and is passed to the AccessLogger once the response is complete.
+type AccessEntry struct {
+	// Time is the time at which the request was received.
+	Time time.Time
+
+	// Remote is the address of the client.
+	Remote string
+
+	// Host is the host requested by the client.
+	Host string
+
+	// Method, URI and Proto are taken from the request line.
+	Method string
+	URI    string
+	Proto  string
+
+	// Status is the HTTP status code of the response, and Bytes is the
+	// size of the response body.
+	Status int
+	Bytes  int64
+
+	// Duration is the time taken to serve the request.
+	Duration time.Duration
+
+	// Referer and UserAgent are taken from the request headers.
+	Referer   string
+	UserAgent string
+
+	// GoGet is set if the request had the `go-get=1` query parameter.
+	GoGet bool
+
+	// CacheHit is set if the upstream check was answered from the cache.
+	CacheHit bool
+
+	// Upstream is the time taken by the upstream check. It is zero if
+	// the upstream was not queried for this request.
+	Upstream time.Duration
+}
+
+// AccessLogger is the interface used by the server to record every request.
+// Implementations must be safe for concurrent use.
+type AccessLogger interface {
+	LogAccess(e *AccessEntry)
+}
+
+// LogFormat is the format in which the access log lines are written
+type LogFormat int
+
+const (
+	// LogText is the default vanity format, prefixed with the date and time
+	LogText LogFormat = iota
+
+	// LogCommon is the Common Log Format
+	LogCommon
+
+	// LogCombined is the Combined Log Format
+	LogCombined
+
+	// LogJSON writes one JSON object per line
+	LogJSON
+)
+
+// ParseLogFormat converts the case-insensitive format name to a LogFormat.
+// It can be one of text, common, combined or json.
+func ParseLogFormat(f string) (LogFormat, error) {
+	switch strings.TrimSpace(strings.ToLower(f)) {
+	case "text", "":
+		return LogText, nil
+
+	case "common", "clf":
+		return LogCommon, nil
+
+	case "combined":
+		return LogCombined, nil
+
+	case "json":
+		return LogJSON, nil
+
+	default:
+		return LogText, fmt.Errorf("Unknown log format %v", f)
+	}
+}
+
+// accessLogger writes the access log entries to an io.Writer in the
+// requested format.
+type accessLogger struct {
+	mu     sync.Mutex
+	w      io.Writer
+	format LogFormat
+}
+
+// NewAccessLogger returns an AccessLogger which writes one line per request
+// to w in the given format.
+func NewAccessLogger(w io.Writer, format LogFormat) AccessLogger {
+	return &accessLogger{w: w, format: format}
+}
+
+// LogAccess formats the entry and writes it to the underlying writer
+func (l *accessLogger) LogAccess(e *AccessEntry) {
+	var line string
+
+	switch l.format {
+	case LogCommon:
+		line = formatCommon(e) + "\n"
+
+	case LogCombined:
+		line = fmt.Sprintf("%s %q %q\n", formatCommon(e), dashIfEmpty(e.Referer), dashIfEmpty(e.UserAgent))
+
+	case LogJSON:
+		line = formatJSON(e)
+
+	default:
+		line = fmt.Sprintf("%s %v \"%v %v %v\" %d %v\n", e.Time.Format("2006/01/02 15:04:05"),
+			e.Remote, e.Method, e.URI, e.Proto, e.Status, e.Duration)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	io.WriteString(l.w, line)
+}
+
+// dashIfEmpty returns "-" for empty fields, as used by the common log format
+func dashIfEmpty(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func formatCommon(e *AccessEntry) string {
+	bytes := "-"
+	if e.Bytes > 0 {
+		bytes = fmt.Sprint(e.Bytes)
+	}
+
+	return fmt.Sprintf("%s - - [%s] \"%s %s %s\" %d %s", dashIfEmpty(e.Remote),
+		e.Time.Format("02/Jan/2006:15:04:05 -0700"), e.Method, e.URI, e.Proto, e.Status, bytes)
+}
+
+func formatJSON(e *AccessEntry) string {
+	data := struct {
+		Time      string  `json:"time"`
+		Remote    string  `json:"remote"`
+		Host      string  `json:"host"`
+		Method    string  `json:"method"`
+		URI       string  `json:"uri"`
+		Proto     string  `json:"proto"`
+		Status    int     `json:"status"`
+		Bytes     int64   `json:"bytes"`
+		Duration  float64 `json:"duration_ms"`
+		Referer   string  `json:"referer,omitempty"`
+		UserAgent string  `json:"user_agent,omitempty"`
+		GoGet     bool    `json:"go_get"`
+		CacheHit  bool    `json:"cache_hit"`
+		Upstream  float64 `json:"upstream_ms,omitempty"`
+	}{
+		Time:      e.Time.Format(time.RFC3339Nano),
+		Remote:    e.Remote,
+		Host:      e.Host,
+		Method:    e.Method,
+		URI:       e.URI,
+		Proto:     e.Proto,
+		Status:    e.Status,
+		Bytes:     e.Bytes,
+		Duration:  milliseconds(e.Duration),
+		Referer:   e.Referer,
+		UserAgent: e.UserAgent,
+		GoGet:     e.GoGet,
+		CacheHit:  e.CacheHit,
+		Upstream:  milliseconds(e.Upstream),
+	}
+
+	out, err := json.Marshal(data)
+	if err != nil {
+		return ""
+	}
+	return string(out) + "\n"
+}
+
+// milliseconds converts the duration to fractional milliseconds
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// LogFile is an io.Writer that appends to a file, and can reopen the file
+// on request. This allows tools like logrotate to move the file away and
+// signal the application to start writing to a new one.
+type LogFile struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+}
+
+// OpenLogFile opens the file at path for appending, creating it if needed
+func OpenLogFile(path string) (*LogFile, error) {
+	f := &LogFile{path: path}
+	if err := f.Reopen(); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// Write appends the data to the currently open file
+func (f *LogFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.file.Write(p)
+}
+
+// Reopen closes the current file and opens the path again. If the file
+// cannot be opened, the existing file is retained.
+func (f *LogFile) Reopen() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file != nil {
+		f.file.Close()
+	}
+	f.file = file
+	return nil
+}
+
+// Close closes the underlying file
+func (f *LogFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.file.Close()
+}
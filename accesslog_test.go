@@ -0,0 +1,188 @@
+// Copyright 2019 Nirenjan Krishnan. All rights reserved.
+
+package vanity
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testEntry() *AccessEntry {
+	return &AccessEntry{
+		Time:      time.Date(2019, time.October, 10, 13, 55, 36, 0, time.FixedZone("", -7*3600)),
+		Remote:    "127.0.0.1",
+		Host:      "nirenjan.org",
+		Method:    "GET",
+		URI:       "/semver?go-get=1",
+		Proto:     "HTTP/1.1",
+		Status:    200,
+		Bytes:     2326,
+		Duration:  time.Millisecond * 3,
+		UserAgent: "Go-http-client/1.1",
+		GoGet:     true,
+		CacheHit:  false,
+		Upstream:  time.Millisecond * 2,
+	}
+}
+
+func TestAccessLogFormats(t *testing.T) {
+	checks := []struct {
+		format LogFormat
+		out    string
+	}{
+		{LogText, "2019/10/10 13:55:36 127.0.0.1 \"GET /semver?go-get=1 HTTP/1.1\" 200 3ms\n"},
+		{LogCommon, "127.0.0.1 - - [10/Oct/2019:13:55:36 -0700] \"GET /semver?go-get=1 HTTP/1.1\" 200 2326\n"},
+		{LogCombined, "127.0.0.1 - - [10/Oct/2019:13:55:36 -0700] \"GET /semver?go-get=1 HTTP/1.1\" 200 2326 \"-\" \"Go-http-client/1.1\"\n"},
+	}
+
+	for _, c := range checks {
+		var buf bytes.Buffer
+		NewAccessLogger(&buf, c.format).LogAccess(testEntry())
+
+		if buf.String() != c.out {
+			t.Errorf("Mismatch in access log format %v, expected %#v, got %#v", c.format, c.out, buf.String())
+		}
+	}
+}
+
+func TestAccessLogJSON(t *testing.T) {
+	var buf bytes.Buffer
+	NewAccessLogger(&buf, LogJSON).LogAccess(testEntry())
+
+	var out map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &out); err != nil {
+		t.Fatalf("Invalid JSON %#v: %v", buf.String(), err)
+	}
+
+	checks := map[string]interface{}{
+		"host":        "nirenjan.org",
+		"status":      200.0,
+		"go_get":      true,
+		"cache_hit":   false,
+		"upstream_ms": 2.0,
+		"user_agent":  "Go-http-client/1.1",
+	}
+	for k, v := range checks {
+		if out[k] != v {
+			t.Errorf("Mismatch in JSON field %v, expected %#v, got %#v", k, v, out[k])
+		}
+	}
+}
+
+func TestParseLogFormat(t *testing.T) {
+	checks := []struct {
+		in  string
+		out LogFormat
+	}{
+		{"", LogText},
+		{"Text", LogText},
+		{"common", LogCommon},
+		{"CLF", LogCommon},
+		{"combined", LogCombined},
+		{"JSON", LogJSON},
+	}
+
+	for _, c := range checks {
+		f, err := ParseLogFormat(c.in)
+		if err != nil || f != c.out {
+			t.Errorf("ParseLogFormat(%#v), expected (%v, nil), got (%v, %v)", c.in, c.out, f, err)
+		}
+	}
+
+	if _, err := ParseLogFormat("apache"); err == nil {
+		t.Errorf("Expected error, got nil")
+	}
+}
+
+func TestLogFileReopen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vanity")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "access.log")
+	f, err := OpenLogFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	f.Write([]byte("first\n"))
+
+	// Simulate logrotate moving the file away
+	os.Rename(path, path+".1")
+	if err := f.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("second\n"))
+
+	for file, exp := range map[string]string{path + ".1": "first\n", path: "second\n"} {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != exp {
+			t.Errorf("Mismatch in %v, expected %#v, got %#v", file, exp, string(data))
+		}
+	}
+}
+
+type recordingLogger struct {
+	entries []AccessEntry
+}
+
+func (l *recordingLogger) LogAccess(e *AccessEntry) {
+	l.entries = append(l.entries, *e)
+}
+
+func TestAccessLogEntry(t *testing.T) {
+	mock := mockServer(t)
+	defer mock.Close()
+
+	s, _ := NewServer("base", mockAddr(mock), "")
+	s.client = mock.Client()
+	s.CacheTTL(time.Minute)
+
+	log := new(recordingLogger)
+	s.AccessLog(log)
+
+	handler := s.getHandler("/", s.handleGeneric)
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest("GET", "/valid?go-get=1", nil)
+		req.Header.Set("User-Agent", "test-agent")
+		req.Header.Set("Referer", "http://example.com/")
+		handler(httptest.NewRecorder(), req)
+	}
+
+	if len(log.entries) != 2 {
+		t.Fatalf("Expected 2 log entries, got %v", len(log.entries))
+	}
+
+	for i, e := range log.entries {
+		if e.Status != http.StatusOK || !e.GoGet || e.UserAgent != "test-agent" ||
+			e.Referer != "http://example.com/" || e.Host != "example.com" ||
+			e.URI != "/valid?go-get=1" || e.Bytes == 0 {
+			t.Errorf("Unexpected log entry %d: %#v", i, e)
+		}
+	}
+
+	if log.entries[0].CacheHit || log.entries[0].Upstream == 0 {
+		t.Errorf("Expected upstream query on first request, got %#v", log.entries[0])
+	}
+	if !log.entries[1].CacheHit || log.entries[1].Upstream != 0 {
+		t.Errorf("Expected cache hit on second request, got %#v", log.entries[1])
+	}
+
+	if !strings.HasPrefix(log.entries[0].Proto, "HTTP/") {
+		t.Errorf("Unexpected protocol %#v", log.entries[0].Proto)
+	}
+}
@@ -0,0 +1,296 @@
+// Copyright 2019 Nirenjan Krishnan. All rights reserved.
+
+package vanity
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// maxAdminBody is the maximum size of a request body for the admin API
+const maxAdminBody = 1 << 16
+
+// adminError is an error from the admin API, along with the HTTP status code
+// to respond with
+type adminError struct {
+	code int
+	msg  string
+}
+
+func (e *adminError) Error() string {
+	return e.msg
+}
+
+// adminErrorCode returns the HTTP status code for the error. Errors which
+// are not admin errors are failures of the server.
+func adminErrorCode(err error) int {
+	if ae, ok := err.(*adminError); ok {
+		return ae.code
+	}
+	return http.StatusInternalServerError
+}
+
+// ServeAdmin enables the admin API on the listener l, which must not be the
+// main listener. If token is not empty, every request must have the header
+// `Authorization: Bearer <token>`. An empty token disables authentication,
+// which is only appropriate if the listener is otherwise protected, e.g., a
+// Unix socket, or a TLS listener which requires client certificates.
+//
+// The API has the following endpoints:
+//
+//	GET    /packages        list the packages
+//	POST   /packages        add a package, which must not exist
+//	GET    /packages/name   get a package
+//	PUT    /packages/name   add or replace a package
+//	DELETE /packages/name   remove a package
+//	DELETE /cache           flush the upstream cache
+//	DELETE /cache/name      remove the cached result for a package
+//	GET    /config          show the effective configuration
+//
+// Packages are sent and received as JSON objects with the fields of Package.
+// The admin API is only started when the server is started.
+func (s *Server) ServeAdmin(l net.Listener, token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.adminListener = l
+	s.adminToken = token
+}
+
+// AdminStore sets the function which saves the packages after they are
+// changed through the admin API. It is called with the complete list of
+// packages, sorted by name, before the packages of the server are changed.
+// If it returns an error, the change is discarded, so that the server and
+// the store don't disagree. The store is called for one change at a time,
+// but concurrently with requests and other changes to the configuration, so
+// an application which reloads the packages from the store must serialize
+// the reload with it. The store may be changed while the server is running.
+func (s *Server) AdminStore(save func(packages []Package) error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.adminStore = save
+}
+
+// AdminHandler returns an http.Handler which serves the admin API, so that
+// it can be served by the application. The token is checked as described
+// for ServeAdmin.
+func (s *Server) AdminHandler(token string) http.Handler {
+	m := http.NewServeMux()
+	m.HandleFunc("/packages", s.handleAdminPackages)
+	m.HandleFunc("/packages/", s.handleAdminPackage)
+	m.HandleFunc("/cache", s.handleAdminCache)
+	m.HandleFunc("/cache/", s.handleAdminCache)
+	m.HandleFunc("/config", s.handleAdminConfig)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token != "" {
+			auth := r.Header.Get("Authorization")
+			if !strings.HasPrefix(auth, "Bearer ") ||
+				subtle.ConstantTimeCompare([]byte(auth[7:]), []byte(token)) != 1 {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				jsonError(w, http.StatusUnauthorized, fmt.Errorf("Invalid or missing token"))
+				return
+			}
+		}
+
+		m.ServeHTTP(w, r)
+	})
+}
+
+// checkMethod checks if the request uses one of the allowed methods, and
+// responds with an error if it does not
+func checkMethod(w http.ResponseWriter, r *http.Request, allowed ...string) bool {
+	for _, m := range allowed {
+		if r.Method == m {
+			return true
+		}
+	}
+
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	jsonError(w, http.StatusMethodNotAllowed, fmt.Errorf("Method %v not allowed", r.Method))
+	return false
+}
+
+// readPackage decodes the package in the request body
+func readPackage(w http.ResponseWriter, r *http.Request) (Package, error) {
+	var p Package
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAdminBody))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&p); err != nil {
+		return p, &adminError{http.StatusBadRequest, fmt.Sprintf("Invalid package: %v", err)}
+	}
+
+	return p, nil
+}
+
+// changePackages applies the change to the packages, saves the new list of
+// packages to the store, and only then replaces the packages of the current
+// configuration. If either of the first two fails, the packages are not
+// changed. The store is called without holding s.mu, since it may be slow,
+// but the changes are serialized by storeMu, so that the store is written
+// in the same order as the packages are changed. The cached result for the
+// package is removed, since the change may affect the upstream check.
+func (s *Server) changePackages(name string, change func(packages map[string]Package) error) error {
+	s.storeMu.Lock()
+	defer s.storeMu.Unlock()
+
+	next := s.config().clone()
+	if err := change(next.packages); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	store := s.adminStore
+	s.mu.Unlock()
+
+	if store != nil {
+		if err := store(next.sortedPackages()); err != nil {
+			return fmt.Errorf("Failed to save packages: %v", err)
+		}
+	}
+
+	// Only the packages are replaced, so that other changes made while
+	// the store was written, e.g., by a reload, are kept
+	s.update(func(c *config) error {
+		c.packages = next.packages
+		return nil
+	})
+
+	s.cache.remove(name)
+	return nil
+}
+
+// handleAdminPackages lists the packages, or adds a new package
+func (s *Server) handleAdminPackages(w http.ResponseWriter, r *http.Request) {
+	if !checkMethod(w, r, http.MethodGet, http.MethodPost) {
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		writeJSON(w, http.StatusOK, s.Packages())
+		return
+	}
+
+	p, err := readPackage(w, r)
+	if err == nil {
+		p, err = cleanPackage(p)
+	}
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	err = s.changePackages(p.Name, func(packages map[string]Package) error {
+		if _, ok := packages[p.Name]; ok {
+			return &adminError{http.StatusConflict, fmt.Sprintf("Package %#v already exists", p.Name)}
+		}
+		packages[p.Name] = p
+		return nil
+	})
+	if err != nil {
+		jsonError(w, adminErrorCode(err), err)
+		return
+	}
+
+	log.Printf("Admin: added package %v", p.Name)
+	w.Header().Set("Location", "/packages/"+p.Name)
+	writeJSON(w, http.StatusCreated, p)
+}
+
+// handleAdminPackage gets, replaces or removes a single package
+func (s *Server) handleAdminPackage(w http.ResponseWriter, r *http.Request) {
+	if !checkMethod(w, r, http.MethodGet, http.MethodPut, http.MethodDelete) {
+		return
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, "/packages/")
+	switch r.Method {
+	case http.MethodGet:
+		p, ok := s.config().packages[name]
+		if !ok {
+			jsonError(w, http.StatusNotFound, fmt.Errorf("Unknown package %#v", name))
+			return
+		}
+		writeJSON(w, http.StatusOK, p)
+
+	case http.MethodPut:
+		p, err := readPackage(w, r)
+		if err == nil && p.Name != "" && p.Name != name {
+			err = fmt.Errorf("Package name %#v does not match the path", p.Name)
+		}
+		if err == nil {
+			p.Name = name
+			p, err = cleanPackage(p)
+		}
+		if err != nil {
+			jsonError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		code := http.StatusOK
+		err = s.changePackages(name, func(packages map[string]Package) error {
+			if _, ok := packages[name]; !ok {
+				code = http.StatusCreated
+			}
+			packages[name] = p
+			return nil
+		})
+		if err != nil {
+			jsonError(w, adminErrorCode(err), err)
+			return
+		}
+
+		log.Printf("Admin: updated package %v", name)
+		writeJSON(w, code, p)
+
+	case http.MethodDelete:
+		err := s.changePackages(name, func(packages map[string]Package) error {
+			if _, ok := packages[name]; !ok {
+				return &adminError{http.StatusNotFound, fmt.Sprintf("Unknown package %#v", name)}
+			}
+			delete(packages, name)
+			return nil
+		})
+		if err != nil {
+			jsonError(w, adminErrorCode(err), err)
+			return
+		}
+
+		log.Printf("Admin: removed package %v", name)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// handleAdminCache removes the cached upstream results for a package, or
+// all of them
+func (s *Server) handleAdminCache(w http.ResponseWriter, r *http.Request) {
+	if !checkMethod(w, r, http.MethodDelete) {
+		return
+	}
+
+	name := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/cache"), "/")
+	if name == "" {
+		s.cache.flush()
+	} else {
+		s.cache.remove(name)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleAdminConfig shows the effective configuration of the server
+func (s *Server) handleAdminConfig(w http.ResponseWriter, r *http.Request) {
+	if !checkMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	fmt.Fprint(w, s.String())
+}
@@ -0,0 +1,162 @@
+// Copyright 2019 Nirenjan Krishnan. All rights reserved.
+
+package vanity
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAdminAPI(t *testing.T) {
+	s, _ := NewServer("nirenjan.org", "https://github.com/nirenjan/", "")
+	s.AddPackage(Package{Name: "semver"})
+
+	var saved []Package
+	s.AdminStore(func(packages []Package) error {
+		saved = packages
+		return nil
+	})
+	h := s.AdminHandler("secret")
+
+	checks := []struct {
+		method string
+		path   string
+		token  string
+		body   string
+		code   int
+		resp   string
+	}{
+		{"GET", "/packages", "", "", http.StatusUnauthorized, "Invalid or missing token"},
+		{"GET", "/packages", "wrong", "", http.StatusUnauthorized, "Invalid or missing token"},
+		{"GET", "/packages", "secret", "", http.StatusOK, `"name": "semver"`},
+		{"POST", "/packages", "secret", `{"name": "vanity", "repo": "https://git.example.com/vanity/"}`,
+			http.StatusCreated, `"repo": "https://git.example.com/vanity"`},
+		{"POST", "/packages", "secret", `{"name": "vanity"}`, http.StatusConflict, "already exists"},
+		{"POST", "/packages", "secret", `{"name": "Vanity"}`, http.StatusBadRequest, "Invalid package name"},
+		{"POST", "/packages", "secret", `{"name": "x", "bogus": 1}`, http.StatusBadRequest, "unknown field"},
+		{"GET", "/packages/vanity", "secret", "", http.StatusOK, `"name": "vanity"`},
+		{"GET", "/packages/quote", "secret", "", http.StatusNotFound, "Unknown package"},
+		{"PUT", "/packages/quote", "secret", `{"version": "v1.5.2"}`, http.StatusCreated, `"version": "v1.5.2"`},
+		{"PUT", "/packages/quote", "secret", `{"description": "Quotes"}`, http.StatusOK, `"description": "Quotes"`},
+		{"PUT", "/packages/quote", "secret", `{"name": "other"}`, http.StatusBadRequest, "does not match"},
+		{"DELETE", "/packages/semver", "secret", "", http.StatusNoContent, ""},
+		{"DELETE", "/packages/semver", "secret", "", http.StatusNotFound, "Unknown package"},
+		{"PATCH", "/packages/quote", "secret", "", http.StatusMethodNotAllowed, "not allowed"},
+		{"DELETE", "/cache", "secret", "", http.StatusNoContent, ""},
+		{"DELETE", "/cache/quote", "secret", "", http.StatusNoContent, ""},
+		{"GET", "/config", "secret", "", http.StatusOK, "Base URL: nirenjan.org"},
+	}
+
+	for _, c := range checks {
+		req := httptest.NewRequest(c.method, c.path, strings.NewReader(c.body))
+		if c.token != "" {
+			req.Header.Set("Authorization", "Bearer "+c.token)
+		}
+
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		if rr.Code != c.code {
+			t.Errorf("Mismatch in status code for %v %v, expected %v, got %v",
+				c.method, c.path, c.code, rr.Code)
+		}
+		if !strings.Contains(rr.Body.String(), c.resp) {
+			t.Errorf("Mismatch in response for %v %v, expected %#v in %#v",
+				c.method, c.path, c.resp, rr.Body.String())
+		}
+	}
+
+	exp := []Package{
+		{Name: "quote", Description: "Quotes"},
+		{Name: "vanity", Repo: "https://git.example.com/vanity"},
+	}
+	if !reflect.DeepEqual(s.Packages(), exp) {
+		t.Errorf("Mismatch in packages, expected %v, got %v", exp, s.Packages())
+	}
+	if !reflect.DeepEqual(saved, exp) {
+		t.Errorf("Mismatch in saved packages, expected %v, got %v", exp, saved)
+	}
+}
+
+func TestAdminStoreFailure(t *testing.T) {
+	mock := mockServer(t)
+	defer mock.Close()
+
+	s, _ := NewServer("nirenjan.org", mockAddr(mock), "")
+	s.client = mock.Client()
+	s.CacheTTL(time.Minute)
+	s.AdminStore(func(packages []Package) error {
+		return fmt.Errorf("read-only file system")
+	})
+	h := s.AdminHandler("")
+
+	// Populate the cache
+	s.Resolve("valid")
+	if n, _ := s.cache.stats(); n != 1 {
+		t.Fatalf("Expected 1 cache entry, got %v", n)
+	}
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("PUT", "/packages/valid", strings.NewReader(`{}`)))
+	if rr.Code != http.StatusInternalServerError || !strings.Contains(rr.Body.String(), "read-only") {
+		t.Errorf("Expected save failure, got %v %v", rr.Code, rr.Body.String())
+	}
+	if len(s.Packages()) != 0 {
+		t.Errorf("Package was added after save failure: %v", s.Packages())
+	}
+	if n, _ := s.cache.stats(); n != 1 {
+		t.Errorf("Cache was changed after save failure")
+	}
+
+	// Invalidating the package removes the cached result
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("DELETE", "/cache/valid", nil))
+	if n, _ := s.cache.stats(); rr.Code != http.StatusNoContent || n != 0 {
+		t.Errorf("Expected the cache to be empty, got %v entries", n)
+	}
+}
+
+// TestAdminStoreOrder checks that the store is called before the packages
+// are changed, without holding the configuration lock, and that changes made
+// while the store runs are kept.
+func TestAdminStoreOrder(t *testing.T) {
+	s, _ := NewServer("nirenjan.org", "https://github.com/nirenjan/", "")
+	s.QueryRemote(false)
+
+	from, _ := NewServer("nirenjan.org", "https://git.example.com/", "")
+	from.AddPackage(Package{Name: "quote"})
+
+	var saved []Package
+	s.AdminStore(func(packages []Package) error {
+		saved = packages
+		if len(s.Packages()) != 0 {
+			t.Errorf("Packages were changed before the store: %v", s.Packages())
+		}
+
+		// A reload while the store runs must not deadlock, and must
+		// not be undone by the change
+		s.Reload(from)
+		return nil
+	})
+	h := s.AdminHandler("")
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("PUT", "/packages/semver", strings.NewReader(`{}`)))
+	if rr.Code != http.StatusCreated && rr.Code != http.StatusOK {
+		t.Fatalf("Unexpected status %v %v", rr.Code, rr.Body.String())
+	}
+
+	if len(saved) != 1 || saved[0].Name != "semver" {
+		t.Errorf("Mismatch in saved packages, got %v", saved)
+	}
+	if p := s.Packages(); len(p) != 1 || p[0].Name != "semver" {
+		t.Errorf("Mismatch in packages, expected the saved packages, got %v", p)
+	}
+	if root := s.config().repo.root; root != "https://git.example.com/" {
+		t.Errorf("Reload was undone by the change, got root %v", root)
+	}
+}
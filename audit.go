@@ -0,0 +1,405 @@
+// Copyright 2019 Nirenjan Krishnan. All rights reserved.
+
+package vanity
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// AuditProblem identifies a kind of problem found by Audit
+type AuditProblem string
+
+// The problems reported by Audit
+const (
+	// AuditMissing is reported if the repository does not exist
+	AuditMissing AuditProblem = "missing"
+
+	// AuditRenamed is reported if the repository redirects to another
+	// location, e.g., because it was renamed or transferred
+	AuditRenamed AuditProblem = "renamed"
+
+	// AuditArchived is reported if the repository is archived
+	AuditArchived AuditProblem = "archived"
+
+	// AuditPrivate is reported if the repository is private, so that it
+	// can't be fetched without credentials
+	AuditPrivate AuditProblem = "private"
+
+	// AuditBranch is reported if the default branch of the repository is
+	// not the branch used by the provider's source templates
+	AuditBranch AuditProblem = "branch"
+
+	// AuditSource is reported if the directory template doesn't resolve
+	AuditSource AuditProblem = "source"
+
+	// AuditError is reported if the repository could not be checked
+	AuditError AuditProblem = "error"
+)
+
+// AuditFinding is a single problem found for a package
+type AuditFinding struct {
+	Problem AuditProblem `json:"problem"`
+	Detail  string       `json:"detail"`
+}
+
+// AuditReport is the result of auditing the repository of a package
+type AuditReport struct {
+	// Package is the name of the package, and is empty for the root
+	// repository. ImportPath is the full import path of the package.
+	Package    string `json:"package"`
+	ImportPath string `json:"import_path"`
+
+	// Repo is the repository URL served in the go-import meta tag
+	Repo string `json:"repo"`
+
+	// Status is the status code returned by the upstream for the
+	// repository, and is 0 if the upstream could not be reached.
+	Status int `json:"upstream_status"`
+
+	// Location is the URL the repository redirects to, if it was renamed
+	Location string `json:"location,omitempty"`
+
+	// DefaultBranch is the default branch of the repository, if it is
+	// known from the provider's API.
+	DefaultBranch string `json:"default_branch,omitempty"`
+
+	// Findings is the list of problems found, and is empty if the
+	// repository is healthy
+	Findings []AuditFinding `json:"findings"`
+}
+
+// OK returns true if no problems were found
+func (r *AuditReport) OK() bool {
+	return len(r.Findings) == 0
+}
+
+// add records a problem with the repository
+func (r *AuditReport) add(p AuditProblem, format string, args ...interface{}) {
+	r.Findings = append(r.Findings, AuditFinding{p, fmt.Sprintf(format, args...)})
+}
+
+// repoMeta is the repository metadata returned by the provider APIs. The
+// fields of all the supported providers are decoded together, since they
+// don't overlap.
+type repoMeta struct {
+	// GitHub, Gitea and Gogs
+	HTMLURL string `json:"html_url"`
+	Private bool   `json:"private"`
+
+	// GitLab
+	WebURL     string `json:"web_url"`
+	Visibility string `json:"visibility"`
+
+	// Bitbucket
+	IsPrivate  bool `json:"is_private"`
+	MainBranch struct {
+		Name string `json:"name"`
+	} `json:"mainbranch"`
+	Links struct {
+		HTML struct {
+			Href string `json:"href"`
+		} `json:"html"`
+	} `json:"links"`
+
+	// Common to all but Bitbucket
+	DefaultBranch string `json:"default_branch"`
+	Archived      bool   `json:"archived"`
+}
+
+// url returns the web URL of the repository
+func (m *repoMeta) url() string {
+	for _, u := range []string{m.HTMLURL, m.WebURL, m.Links.HTML.Href} {
+		if u != "" {
+			return u
+		}
+	}
+	return ""
+}
+
+// branch returns the default branch of the repository
+func (m *repoMeta) branch() string {
+	if m.DefaultBranch != "" {
+		return m.DefaultBranch
+	}
+	return m.MainBranch.Name
+}
+
+// private returns true if the repository is not public
+func (m *repoMeta) private() bool {
+	return m.Private || m.IsPrivate || m.Visibility != "" && m.Visibility != "public"
+}
+
+// Audit checks the repositories of the known packages and the root
+// repository, if there is one, along with the packages which were found
+// upstream and are still in the cache. Unlike the upstream check done while
+// serving, it reports repositories which are missing, have been renamed,
+// archived or made private, and whether the default branch and the
+// directory template match the repository. The checks are always made,
+// even if the server doesn't query the remote, and the cache is neither
+// used nor updated.
+//
+// The metadata of repositories at GitHub, GitLab, Bitbucket, Gitea and Gogs
+// is fetched from the provider's API. The token is only sent to the API for
+// repositories on the given host, or on the host of the VCS root if the
+// host is empty, so that it is never sent to another provider. The token is
+// required to see private repositories. It is sent as a bearer token, except
+// to Gogs, which uses the `token` scheme, and a Bitbucket app password, given
+// as `username:app-password`, which uses basic authentication. Other
+// repositories are only checked for existence.
+func (s *Server) Audit(token, host string) []*AuditReport {
+	c := s.config()
+	if host == "" {
+		if u, err := url.Parse(c.repo.root); err == nil {
+			host = u.Host
+		}
+	}
+
+	var reports []*AuditReport
+	if c.rootRepo != "" {
+		reports = append(reports, s.auditRepo(c, "", token, host))
+	}
+
+	names := make(map[string]bool)
+	for _, p := range c.sortedPackages() {
+		names[p.Name] = true
+	}
+	for _, base := range s.cache.found() {
+		if base != "" {
+			names[base] = true
+		}
+	}
+
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+	for _, name := range sorted {
+		reports = append(reports, s.auditRepo(c, name, token, host))
+	}
+	return reports
+}
+
+// auditRepo audits the repository of the named package. The token is only
+// used if the repository is on the token host.
+func (s *Server) auditRepo(c *config, name, token, host string) *AuditReport {
+	r := &AuditReport{
+		Package:    name,
+		ImportPath: strings.TrimSuffix(c.base+"/"+name, "/"),
+		Repo:       c.repoURL(name),
+	}
+
+	resp, err := s.client.Head(r.Repo)
+	if err != nil {
+		r.add(AuditError, "Failed to query the repository: %v", err)
+		return r
+	}
+	resp.Body.Close()
+	r.Status = resp.StatusCode
+
+	// The client follows the redirects, so a renamed repository is found
+	// at a different URL. Providers redirect private repositories to the
+	// sign in page instead.
+	final := resp.Request.URL.String()
+	moved := resp.StatusCode == http.StatusOK && !sameRepo(r.Repo, final)
+	if moved && isLoginPage(resp.Request.URL) {
+		r.add(AuditPrivate, "The repository redirects to the sign in page %v", final)
+		return r
+	}
+
+	if u, err := url.Parse(r.Repo); err != nil || host == "" || !strings.EqualFold(u.Host, host) {
+		token = ""
+	}
+	meta, err := s.repoMeta(c, r.Repo, token)
+	if err != nil {
+		r.add(AuditError, "%v", err)
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusOK:
+	case meta != nil && meta.private():
+		r.add(AuditPrivate, "The repository is private")
+		return r
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		r.add(AuditMissing, "The repository was not found (%v %v)", resp.StatusCode, http.StatusText(resp.StatusCode))
+		return r
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		r.add(AuditPrivate, "Access to the repository was denied (%v %v)", resp.StatusCode, http.StatusText(resp.StatusCode))
+		return r
+	default:
+		r.add(AuditError, "Unexpected status from the repository (%v %v)", resp.StatusCode, http.StatusText(resp.StatusCode))
+		return r
+	}
+
+	if moved {
+		r.Location = final
+	} else if meta != nil && meta.url() != "" && !sameRepo(r.Repo, meta.url()) {
+		r.Location = meta.url()
+	}
+	if r.Location != "" {
+		r.add(AuditRenamed, "The repository has moved to %v", r.Location)
+	}
+
+	if meta != nil {
+		r.DefaultBranch = meta.branch()
+		if meta.Archived {
+			r.add(AuditArchived, "The repository is archived")
+		}
+		if meta.private() {
+			r.add(AuditPrivate, "The repository is private")
+		}
+		if c.repo.branch != "" && r.DefaultBranch != "" && r.DefaultBranch != c.repo.branch {
+			r.add(AuditBranch, "The source templates use the branch %v, but the default branch is %v",
+				c.repo.branch, r.DefaultBranch)
+		}
+	}
+
+	if c.repo.dirFormat != "" {
+		source := c.repo.sourceURL(r.Repo, "", "")
+		resp, err := s.client.Head(source)
+		if err != nil {
+			r.add(AuditSource, "Failed to query the source directory %v: %v", source, err)
+		} else {
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				r.add(AuditSource, "The source directory %v was not found (%v %v)",
+					source, resp.StatusCode, http.StatusText(resp.StatusCode))
+			}
+		}
+	}
+
+	return r
+}
+
+// repoMeta fetches the metadata of the repository from the provider's API.
+// It returns nil if the provider of the repository is not known.
+func (s *Server) repoMeta(c *config, repo, token string) (*repoMeta, error) {
+	api, provider := providerAPI(c, repo)
+	if api == "" {
+		return nil, nil
+	}
+
+	req, err := http.NewRequest("GET", api, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	setAPIAuth(req, provider, token)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to query the provider API: %v", err)
+	}
+	defer resp.Body.Close()
+
+	// The APIs hide private repositories from clients without access
+	if resp.StatusCode == http.StatusNotFound {
+		io.Copy(ioutil.Discard, resp.Body)
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Unexpected status from the provider API %v (%v %v)",
+			api, resp.StatusCode, http.StatusText(resp.StatusCode))
+	}
+
+	meta := new(repoMeta)
+	if err := json.NewDecoder(resp.Body).Decode(meta); err != nil {
+		return nil, fmt.Errorf("Invalid response from the provider API %v: %v", api, err)
+	}
+	return meta, nil
+}
+
+// setAPIAuth sets the credentials for the provider's API. Bitbucket app
+// passwords are given as `username:app-password`, and are sent with basic
+// authentication, while its access tokens are sent as bearer tokens. Gogs
+// only accepts its own `token` scheme, and the other providers accept bearer
+// tokens.
+func setAPIAuth(req *http.Request, provider, token string) {
+	if token == "" {
+		return
+	}
+
+	switch user, password, basic := strings.Cut(token, ":"); {
+	case provider == "bitbucket" && basic:
+		req.SetBasicAuth(user, password)
+	case provider == "gogs":
+		req.Header.Set("Authorization", "token "+token)
+	default:
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+}
+
+// providerAPI returns the API URL for the metadata of the repository, and
+// the provider which serves it. The provider is known from the host of the
+// well known hosting sites, or is the configured provider if the repository
+// is on the same host as the VCS root. It returns empty strings if the
+// provider is not known.
+func providerAPI(c *config, repo string) (string, string) {
+	u, err := url.Parse(repo)
+	if err != nil || u.Host == "" {
+		return "", ""
+	}
+	path := strings.TrimSuffix(strings.Trim(u.Path, "/"), ".git")
+
+	provider := ""
+	switch strings.ToLower(u.Host) {
+	case "github.com":
+		return "https://api.github.com/repos/" + path, "github"
+	case "bitbucket.org":
+		return "https://api.bitbucket.org/2.0/repositories/" + path, "bitbucket"
+	case "gitlab.com":
+		provider = "gitlab"
+	case "codeberg.org", "gitea.com":
+		provider = "gitea"
+	default:
+		root, err := url.Parse(c.repo.root)
+		if err != nil || !strings.EqualFold(root.Host, u.Host) {
+			return "", ""
+		}
+		provider = strings.ToLower(strings.TrimSpace(c.repo.provider))
+	}
+
+	site := u.Scheme + "://" + u.Host
+	switch provider {
+	case "github":
+		// GitHub Enterprise
+		return site + "/api/v3/repos/" + path, provider
+	case "gitlab":
+		return site + "/api/v4/projects/" + url.PathEscape(path), provider
+	case "gitea", "gogs":
+		return site + "/api/v1/repos/" + path, provider
+	}
+	return "", ""
+}
+
+// sameRepo returns true if both URLs refer to the same repository,
+// ignoring the scheme, case, and any trailing slash or .git suffix
+func sameRepo(a, b string) bool {
+	clean := func(s string) string {
+		u, err := url.Parse(s)
+		if err != nil {
+			return s
+		}
+		return u.Host + strings.TrimSuffix(strings.TrimSuffix(u.Path, "/"), ".git")
+	}
+	return strings.EqualFold(clean(a), clean(b))
+}
+
+// isLoginPage returns true if the URL looks like the sign in page of a
+// hosting provider
+func isLoginPage(u *url.URL) bool {
+	p := strings.ToLower(u.Path)
+	for _, page := range []string{"/login", "/sign_in", "/signin"} {
+		if strings.HasSuffix(p, page) || strings.Contains(p, page+"/") {
+			return true
+		}
+	}
+	return false
+}
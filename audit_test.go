@@ -0,0 +1,220 @@
+// Copyright 2019 Nirenjan Krishnan. All rights reserved.
+
+package vanity
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// mockProvider serves the repositories and the API of a GitHub Enterprise
+// like provider. Private repositories are only visible in the API with the
+// token.
+func mockProvider(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		api := func(name, branch string, archived, private bool) {
+			fmt.Fprintf(w, `{"html_url": "%v/nirenjan/%v", "default_branch": "%v", "archived": %v, "private": %v}`,
+				ts.URL, name, branch, archived, private)
+		}
+
+		// Renamed repositories redirect every path to the new name
+		if strings.HasPrefix(r.URL.Path, "/nirenjan/oldname") {
+			path := strings.Replace(r.URL.Path, "oldname", "quote", 1)
+			http.Redirect(w, r, path, http.StatusMovedPermanently)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/nirenjan/semver", "/nirenjan/semver/tree/master",
+			"/nirenjan/quote", "/nirenjan/quote/tree/master",
+			"/nirenjan/uuid", "/nirenjan/uuid/tree/master",
+			"/nirenjan/root", "/nirenjan/root/tree/master",
+			"/nirenjan/trunk", "/nirenjan/trunk/tree/main":
+		case "/nirenjan/internal":
+			http.Redirect(w, r, "/login?return_to=/nirenjan/internal", http.StatusFound)
+		case "/login":
+
+		case "/api/v3/repos/nirenjan/semver":
+			api("semver", "master", false, false)
+		case "/api/v3/repos/nirenjan/oldname", "/api/v3/repos/nirenjan/quote":
+			api("quote", "master", false, false)
+		case "/api/v3/repos/nirenjan/uuid":
+			api("uuid", "master", true, false)
+		case "/api/v3/repos/nirenjan/trunk":
+			api("trunk", "main", false, false)
+		case "/api/v3/repos/nirenjan/secret":
+			if r.Header.Get("Authorization") != "Bearer s3cret" {
+				http.NotFound(w, r)
+				return
+			}
+			api("secret", "master", false, true)
+
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	return ts
+}
+
+func TestAudit(t *testing.T) {
+	mock := mockProvider(t)
+	defer mock.Close()
+
+	s, _ := NewServer("nirenjan.org", mock.URL+"/nirenjan/", "")
+	s.client = mock.Client()
+	s.Repo().SetProvider("github")
+	s.RootRepo(mock.URL + "/nirenjan/root")
+	for _, name := range []string{"semver", "oldname", "uuid", "trunk", "missing", "secret", "internal"} {
+		s.AddPackage(Package{Name: name})
+	}
+	s.AddPackage(Package{Name: "elsewhere", Repo: "http://127.0.0.1:1/elsewhere"})
+
+	checks := []struct {
+		pkg      string
+		status   int
+		problems []AuditProblem
+	}{
+		{"", http.StatusOK, nil},
+		{"elsewhere", 0, []AuditProblem{AuditError}},
+		{"internal", http.StatusOK, []AuditProblem{AuditPrivate}},
+		{"missing", http.StatusNotFound, []AuditProblem{AuditMissing}},
+		{"oldname", http.StatusOK, []AuditProblem{AuditRenamed}},
+		{"secret", http.StatusNotFound, []AuditProblem{AuditPrivate}},
+		{"semver", http.StatusOK, nil},
+		{"trunk", http.StatusOK, []AuditProblem{AuditBranch, AuditSource}},
+		{"uuid", http.StatusOK, []AuditProblem{AuditArchived}},
+	}
+
+	reports := s.Audit("s3cret", "")
+	if len(reports) != len(checks) {
+		t.Fatalf("Mismatch in number of reports, expected %v, got %v", len(checks), len(reports))
+	}
+
+	for i, c := range checks {
+		r := reports[i]
+		var got []AuditProblem
+		for _, f := range r.Findings {
+			got = append(got, f.Problem)
+		}
+
+		if r.Package != c.pkg || r.Status != c.status || fmt.Sprint(got) != fmt.Sprint(c.problems) {
+			t.Errorf("Mismatch in audit of %#v, expected (%v, %v), got (%v, %v, %v): %v",
+				c.pkg, c.status, c.problems, r.Package, r.Status, got, r.Findings)
+		}
+		if r.OK() != (len(c.problems) == 0) {
+			t.Errorf("Mismatch in OK for %#v, expected %v", c.pkg, len(c.problems) == 0)
+		}
+	}
+
+	if reports[0].ImportPath != "nirenjan.org" || reports[6].ImportPath != "nirenjan.org/semver" {
+		t.Errorf("Mismatch in import paths, got %v and %v", reports[0].ImportPath, reports[6].ImportPath)
+	}
+	if reports[4].Location != mock.URL+"/nirenjan/quote" {
+		t.Errorf("Mismatch in location of renamed repository, got %v", reports[4].Location)
+	}
+	if reports[7].DefaultBranch != "main" {
+		t.Errorf("Mismatch in default branch, expected main, got %v", reports[7].DefaultBranch)
+	}
+
+	// Without the token, the private repository can't be told apart from
+	// a missing one
+	reports = s.Audit("", "")
+	if f := reports[5].Findings; len(f) != 1 || f[0].Problem != AuditMissing {
+		t.Errorf("Mismatch in audit of private repository without token, got %v", f)
+	}
+
+	// The token is not sent to the API of another host
+	reports = s.Audit("s3cret", "github.com")
+	if f := reports[5].Findings; len(f) != 1 || f[0].Problem != AuditMissing {
+		t.Errorf("Mismatch in audit of private repository with token for another host, got %v", f)
+	}
+
+	// Packages found upstream are audited along with the known packages,
+	// but not those which were not found
+	s.CacheTTL(time.Minute)
+	s.cache.put("discovered", cacheEntry{exists: true, code: http.StatusOK, checked: time.Now()})
+	s.cache.put("absent", cacheEntry{exists: false, code: http.StatusNotFound, checked: time.Now()})
+	s.cache.put("semver", cacheEntry{exists: true, code: http.StatusOK, checked: time.Now()})
+
+	reports = s.Audit("s3cret", "")
+	if len(reports) != len(checks)+1 || reports[1].Package != "discovered" || reports[1].OK() {
+		t.Errorf("Mismatch in audit of discovered package, got %v reports, second %#v", len(reports), reports[1])
+	}
+}
+
+func TestProviderAPI(t *testing.T) {
+	s, _ := NewServer("nirenjan.org", "https://git.example.com/nirenjan/", "")
+	cfg := s.config()
+
+	checks := []struct {
+		provider string
+		repo     string
+		api      string
+	}{
+		{"", "https://github.com/nirenjan/semver", "https://api.github.com/repos/nirenjan/semver"},
+		{"", "https://github.com/nirenjan/semver.git/", "https://api.github.com/repos/nirenjan/semver"},
+		{"", "https://bitbucket.org/nirenjan/semver", "https://api.bitbucket.org/2.0/repositories/nirenjan/semver"},
+		{"", "https://gitlab.com/nirenjan/go/semver", "https://gitlab.com/api/v4/projects/nirenjan%2Fgo%2Fsemver"},
+		{"", "https://codeberg.org/nirenjan/semver", "https://codeberg.org/api/v1/repos/nirenjan/semver"},
+		{"", "https://git.example.com/nirenjan/semver", ""},
+		{"github", "https://git.example.com/nirenjan/semver", "https://git.example.com/api/v3/repos/nirenjan/semver"},
+		{"gitea", "https://git.example.com/nirenjan/semver", "https://git.example.com/api/v1/repos/nirenjan/semver"},
+		{"gitea", "https://git.other.com/nirenjan/semver", ""},
+		{"bitbucket", "https://git.example.com/nirenjan/semver", ""},
+		{"github", "/nirenjan/semver", ""},
+	}
+
+	for _, c := range checks {
+		cfg.repo.provider = c.provider
+		if api, _ := providerAPI(cfg, c.repo); api != c.api {
+			t.Errorf("Mismatch in API for %v with provider %#v, expected %v, got %v",
+				c.repo, c.provider, c.api, api)
+		}
+	}
+
+	auth := []struct {
+		provider string
+		token    string
+		header   string
+	}{
+		{"github", "s3cret", "Bearer s3cret"},
+		{"gitlab", "s3cret", "Bearer s3cret"},
+		{"gitea", "s3cret", "Bearer s3cret"},
+		{"gogs", "s3cret", "token s3cret"},
+		{"bitbucket", "nirenjan:app:pass", "Basic bmlyZW5qYW46YXBwOnBhc3M="},
+		{"bitbucket", "s3cret", "Bearer s3cret"},
+		{"github", "", ""},
+	}
+
+	for _, c := range auth {
+		req := httptest.NewRequest("GET", "/", nil)
+		setAPIAuth(req, c.provider, c.token)
+		if h := req.Header.Get("Authorization"); h != c.header {
+			t.Errorf("Mismatch in authorization for %v with token %#v, expected %#v, got %#v",
+				c.provider, c.token, c.header, h)
+		}
+	}
+
+	for _, p := range []string{"/login", "/users/sign_in", "/user/login", "/signin/"} {
+		u, _ := url.Parse("https://git.example.com" + p)
+		if !isLoginPage(u) {
+			t.Errorf("Expected %v to be a sign in page", p)
+		}
+	}
+	u, _ := url.Parse("https://git.example.com/nirenjan/login-helper")
+	if isLoginPage(u) {
+		t.Errorf("Unexpected sign in page for login-helper")
+	}
+	if !sameRepo("https://GitHub.com/nirenjan/semver.git", "http://github.com/nirenjan/semver/") ||
+		sameRepo("https://github.com/nirenjan/semver", "https://github.com/nirenjan/quote") {
+		t.Errorf("Mismatch in sameRepo")
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2019 Nirenjan Krishnan. All rights reserved.
+
+package vanity
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// cacheEntry is the result of a single upstream check
+type cacheEntry struct {
+	exists  bool
+	code    int
+	checked time.Time
+}
+
+// upstreamCache saves the results of the upstream checks, so that repeated
+// requests for the same package don't query the remote every time.
+type upstreamCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]cacheEntry
+}
+
+// get returns the cached result for the repository base, if it is present
+// and has not expired.
+func (c *upstreamCache) get(base string) (cacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[base]
+	if !ok || c.ttl <= 0 {
+		return cacheEntry{}, false
+	}
+
+	if time.Since(e.checked) >= c.ttl {
+		delete(c.entries, base)
+		return cacheEntry{}, false
+	}
+
+	return e, true
+}
+
+// put saves the result for the repository base. Results which don't
+// indicate a definite answer from the upstream are not cached.
+func (c *upstreamCache) put(base string, e cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.ttl <= 0 || e.code >= 500 {
+		return
+	}
+
+	if c.entries == nil {
+		c.entries = make(map[string]cacheEntry)
+	}
+	c.entries[base] = e
+}
+
+// setTTL changes the lifetime of the cache entries, and flushes the cache
+func (c *upstreamCache) setTTL(ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.ttl = ttl
+	c.entries = nil
+}
+
+// stats returns the number of entries in the cache and the TTL
+func (c *upstreamCache) stats() (int, time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.entries), c.ttl
+}
+
+// found returns the repository bases which were found upstream, and have
+// not expired, sorted by name
+func (c *upstreamCache) found() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var bases []string
+	for base, e := range c.entries {
+		if e.exists && c.ttl > 0 && time.Since(e.checked) < c.ttl {
+			bases = append(bases, base)
+		}
+	}
+
+	sort.Strings(bases)
+	return bases
+}
+
+// remove deletes the cached result for the repository base
+func (c *upstreamCache) remove(base string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, base)
+}
+
+// flush deletes all the cached results
+func (c *upstreamCache) flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = nil
+}
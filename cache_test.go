@@ -0,0 +1,51 @@
+// Copyright 2019 Nirenjan Krishnan. All rights reserved.
+
+package vanity
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestUpstreamCache(t *testing.T) {
+	var c upstreamCache
+
+	// A zero TTL disables the cache
+	c.put("semver", cacheEntry{true, http.StatusOK, time.Now()})
+	if _, ok := c.get("semver"); ok {
+		t.Errorf("Expected cache miss with TTL 0")
+	}
+
+	c.setTTL(time.Minute)
+	c.put("semver", cacheEntry{true, http.StatusOK, time.Now()})
+	c.put("missing", cacheEntry{false, http.StatusNotFound, time.Now()})
+	c.put("stale", cacheEntry{true, http.StatusOK, time.Now().Add(-time.Hour)})
+	c.put("error", cacheEntry{false, http.StatusServiceUnavailable, time.Now()})
+
+	checks := []struct {
+		base   string
+		ok     bool
+		exists bool
+	}{
+		{"semver", true, true},
+		{"missing", true, false},
+		{"stale", false, false},
+		{"error", false, false},
+		{"unknown", false, false},
+	}
+
+	for _, chk := range checks {
+		e, ok := c.get(chk.base)
+		if ok != chk.ok || e.exists != chk.exists {
+			t.Errorf("Mismatch in upstreamCache.get(%v), expected (%v, %v), got (%v, %v)",
+				chk.base, chk.exists, chk.ok, e.exists, ok)
+		}
+	}
+
+	// Changing the TTL flushes the cache
+	c.setTTL(time.Hour)
+	if _, ok := c.get("semver"); ok {
+		t.Errorf("Expected cache miss after changing the TTL")
+	}
+}
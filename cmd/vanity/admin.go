@@ -0,0 +1,518 @@
+package main // import nirenjan.org/vanity/cmd/vanity
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"nirenjan.org/vanity"
+)
+
+// validateAdmin checks that the admin API is protected by a token or client
+// certificates, unless it is on a Unix socket
+func (o *options) validateAdmin() error {
+	if (o.adminCert == "") != (o.adminKey == "") {
+		return fmt.Errorf("Both -admin-cert and -admin-key are required for TLS")
+	}
+
+	if o.adminClientCA != "" && o.adminCert == "" {
+		return fmt.Errorf("-admin-client-ca requires -admin-cert and -admin-key")
+	}
+
+	if o.adminListen != "" && !strings.HasPrefix(o.adminListen, "unix:") &&
+		o.adminTokenFile == "" && o.adminClientCA == "" {
+		return fmt.Errorf("The admin API on a TCP address requires -admin-token-file or -admin-client-ca")
+	}
+
+	return nil
+}
+
+// listenAdmin creates the listener for the admin API. The address is either
+// host:port, or unix:path for a Unix socket. If a certificate is given, the
+// listener uses TLS, and requires client certificates signed by the client
+// CA, if one is given.
+func (o *options) listenAdmin() (net.Listener, error) {
+	var l net.Listener
+	var err error
+	if strings.HasPrefix(o.adminListen, "unix:") {
+		l, err = net.Listen("unix", strings.TrimPrefix(o.adminListen, "unix:"))
+	} else {
+		l, err = net.Listen("tcp", o.adminListen)
+	}
+	if err != nil || o.adminCert == "" {
+		return l, err
+	}
+
+	cert, err := tls.LoadX509KeyPair(o.adminCert, o.adminKey)
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if o.adminClientCA != "" {
+		data, err := ioutil.ReadFile(o.adminClientCA)
+		if err != nil {
+			l.Close()
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			l.Close()
+			return nil, fmt.Errorf("No certificates found in %v", o.adminClientCA)
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return tls.NewListener(l, cfg), nil
+}
+
+// readToken reads a token from the file, ignoring any surrounding
+// whitespace. It returns an empty token if the path is empty.
+func readToken(path string) (string, error) {
+	if path == "" {
+		return "", nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("Empty token in %v", path)
+	}
+	return token, nil
+}
+
+// configPath returns the config file given on the command line or in the
+// environment
+func (o *options) configPath() string {
+	if o.configFile != "" {
+		return o.configFile
+	}
+	return os.Getenv(envName("config"))
+}
+
+// adminStore returns the store for the admin API, or nil if there is no
+// config file to save the packages in. If the packages are given on the
+// command line or in the environment, they replace those in the config file
+// on a reload, so the store rejects all changes instead of losing them.
+func (o *options) adminStore(fs *flag.FlagSet) func([]vanity.Package) error {
+	for _, p := range o.packages {
+		if p.source == "-package" || p.source == envName("package") {
+			source := p.source
+			return func([]vanity.Package) error {
+				return fmt.Errorf("The packages are given by %v, so changes can't be saved to the config file", source)
+			}
+		}
+	}
+
+	if path := o.configPath(); path != "" {
+		return configStore(fs, path)
+	}
+	return nil
+}
+
+// configStore returns the store for the admin API, which saves the packages
+// in the config file. In TOML, only the packages are replaced, so that the
+// other settings and comments are kept as they are. JSON files are written
+// again from the settings, which keeps the settings but not the formatting.
+func configStore(fs *flag.FlagSet, path string) func([]vanity.Package) error {
+	kinds := flagKinds(fs)
+
+	return func(list []vanity.Package) error {
+		// Reloads read the file, so they must not run while it is
+		// being replaced
+		reloadMu.Lock()
+		defer reloadMu.Unlock()
+
+		var data []byte
+		var err error
+		if strings.ToLower(filepath.Ext(path)) == ".json" {
+			data, err = saveJSON(path, kinds, list)
+		} else {
+			data, err = saveTOML(path, list)
+		}
+		if err != nil {
+			return err
+		}
+
+		return writeFile(path, data)
+	}
+}
+
+// flagKinds returns the type of the boolean and integer flags, which are
+// written without quotes. The types are read once when the store is created,
+// so that saving doesn't read the flags.
+func flagKinds(fs *flag.FlagSet) map[string]string {
+	kinds := make(map[string]string)
+	fs.VisitAll(func(f *flag.Flag) {
+		g, ok := f.Value.(flag.Getter)
+		if !ok {
+			return
+		}
+
+		switch g.Get().(type) {
+		case bool:
+			kinds[f.Name] = "bool"
+		case int:
+			kinds[f.Name] = "int"
+		}
+	})
+	return kinds
+}
+
+// saveJSON writes the settings in the JSON config file again, with the
+// packages replaced by the list
+func saveJSON(path string, kinds map[string]string, list []vanity.Package) ([]byte, error) {
+	settings, err := readConfig(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var kept []setting
+	for _, s := range settings {
+		if s.name != "package" {
+			kept = append(kept, s)
+		}
+	}
+	if len(list) != 0 {
+		kept = append(kept, packageSetting(list))
+	}
+
+	return formatJSON(kinds, kept), nil
+}
+
+// tomlHeader matches the header of a table, and tomlPackage matches the
+// header of a package table
+var (
+	tomlHeader  = regexp.MustCompile(`^\s*\[\[?\s*[\w."' -]+\s*\]\]?\s*(?:#.*)?\s*$`)
+	tomlPackage = regexp.MustCompile(`^\s*\[\[\s*package\s*\]\]\s*(?:#.*)?\s*$`)
+)
+
+// saveTOML replaces the packages in the TOML config file, keeping the rest
+// of the text. A package array is replaced where it is. Each [[package]]
+// section runs up to the next table header, but the comments right before
+// that header are kept. The new sections are written in place of the first
+// removed one, or at the end, since they must come after the top-level
+// settings. The result is parsed again, so that an invalid file is never
+// written.
+func saveTOML(path string, list []vanity.Package) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	settings, err := readConfig(path)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := strings.SplitAfter(string(data), "\n")
+	removed := make([]bool, len(lines))
+	array, table, header := -1, -1, -1
+	for _, s := range settings {
+		if s.name != "package" || tomlPackage.MatchString(lines[s.line-1]) {
+			continue
+		}
+
+		// The array may span several lines, and ends on the first line
+		// which completes it
+		array = s.line - 1
+		end := array + 1
+		for ; end < len(lines); end++ {
+			var v map[string]interface{}
+			if _, err := toml.Decode(strings.Join(lines[array:end], ""), &v); err == nil {
+				break
+			}
+		}
+		for i := array; i < end; i++ {
+			removed[i] = true
+		}
+	}
+
+	for i := 0; i < len(lines); i++ {
+		if !tomlHeader.MatchString(lines[i]) {
+			continue
+		}
+		if header < 0 {
+			header = i
+		}
+		if !tomlPackage.MatchString(lines[i]) {
+			continue
+		}
+		if table < 0 {
+			table = i
+		}
+
+		end := i + 1
+		for end < len(lines) && !tomlHeader.MatchString(lines[end]) {
+			end++
+		}
+
+		// Keep the comments before the next header, but not the blank
+		// lines which separate them from the table
+		keep := end
+		for keep > i+1 && (strings.TrimSpace(lines[keep-1]) == "" ||
+			strings.HasPrefix(strings.TrimSpace(lines[keep-1]), "#")) {
+			keep--
+		}
+		for keep < end && strings.TrimSpace(lines[keep]) == "" {
+			keep++
+		}
+		for j := i; j < keep; j++ {
+			removed[j] = true
+		}
+		i = end - 1
+	}
+
+	// An array must be a top-level setting, so it is written before the
+	// first table, while tables are written after the top-level settings
+	s := packageSetting(list)
+	at := len(lines)
+	switch {
+	case len(s.tables) == 0 && array >= 0:
+		at = array
+	case table >= 0 && (table == header || len(s.tables) != 0):
+		at = table
+	case len(s.tables) == 0 && header >= 0:
+		at = header
+	}
+
+	var before, after strings.Builder
+	for i, l := range lines {
+		if removed[i] {
+			continue
+		}
+		if i < at {
+			before.WriteString(l)
+		} else {
+			after.WriteString(l)
+		}
+	}
+
+	head, tail := before.String(), after.String()
+	if head != "" && !strings.HasSuffix(head, "\n") {
+		head += "\n"
+	}
+
+	text := head + tail
+	if len(list) != 0 {
+		body := formatTOML(s)
+		if len(s.tables) != 0 && head != "" && !strings.HasSuffix(head, "\n\n") {
+			head = strings.TrimRight(head, "\n") + "\n\n"
+		}
+		if at != array && tail != "" && !strings.HasPrefix(tail, "\n") {
+			body += "\n"
+		}
+		text = head + body + tail
+	}
+	if strings.TrimSpace(text) != "" {
+		text = strings.TrimRight(text, "\n") + "\n"
+	}
+
+	if _, err := parseTOML([]byte(text)); err != nil {
+		return nil, fmt.Errorf("Failed to replace the packages in %v: %v", path, err)
+	}
+	return []byte(text), nil
+}
+
+// packageSetting converts the packages to a setting. Packages with just a
+// name are stored as values, and the others as tables. If there are any
+// tables, all the packages are stored as tables, which is easier to read
+// than a mix of strings and inline tables.
+func packageSetting(list []vanity.Package) setting {
+	s := setting{name: "package"}
+	for _, p := range list {
+		if p != (vanity.Package{Name: p.Name}) {
+			s.values = nil
+			break
+		}
+		s.values = append(s.values, p.Name)
+	}
+	if s.values != nil {
+		return s
+	}
+
+	for _, p := range list {
+		var t table
+		for _, f := range [][2]string{
+			{"name", p.Name},
+			{"repo", p.Repo},
+			{"description", p.Description},
+			{"docs", p.Docs},
+			{"version", p.Version},
+		} {
+			if f[1] != "" {
+				t.fields = append(t.fields, setting{name: f[0], values: []string{f[1]}})
+			}
+		}
+		s.tables = append(s.tables, t)
+	}
+
+	return s
+}
+
+// isLiteral checks if the value of the setting is written without quotes,
+// which is the case for booleans and integers
+func isLiteral(kinds map[string]string, name, v string) bool {
+	switch kinds[name] {
+	case "bool":
+		return v == "true" || v == "false"
+	case "int":
+		_, err := strconv.Atoi(v)
+		return err == nil
+	}
+	return false
+}
+
+// formatJSON writes the settings as a JSON object
+func formatJSON(kinds map[string]string, settings []setting) []byte {
+	quote := func(name, v string) string {
+		if isLiteral(kinds, name, v) {
+			return v
+		}
+		b, _ := json.Marshal(v)
+		return string(b)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("{\n")
+	for i, s := range settings {
+		fmt.Fprintf(&buf, "\t%v: ", quote("", s.name))
+		if !listFlags[s.name] {
+			buf.WriteString(quote(s.name, s.values[0]))
+		} else {
+			var elems []string
+			for _, v := range s.values {
+				elems = append(elems, quote(s.name, v))
+			}
+			for _, t := range s.tables {
+				var fields []string
+				for _, f := range t.fields {
+					fields = append(fields, quote("", f.name)+": "+quote("", f.values[0]))
+				}
+				elems = append(elems, "{"+strings.Join(fields, ", ")+"}")
+			}
+
+			if len(s.tables) == 0 {
+				buf.WriteString("[" + strings.Join(elems, ", ") + "]")
+			} else {
+				buf.WriteString("[\n\t\t" + strings.Join(elems, ",\n\t\t") + "\n\t]")
+			}
+		}
+
+		if i < len(settings)-1 {
+			buf.WriteString(",")
+		}
+		buf.WriteString("\n")
+	}
+	buf.WriteString("}\n")
+
+	return buf.Bytes()
+}
+
+// formatTOML writes the package setting as TOML, either as an array of
+// names, or as arrays of tables
+func formatTOML(s setting) string {
+	var buf bytes.Buffer
+	if len(s.tables) == 0 {
+		var elems []string
+		for _, v := range s.values {
+			elems = append(elems, quoteTOML(v))
+		}
+		fmt.Fprintf(&buf, "%v = [%v]\n", s.name, strings.Join(elems, ", "))
+		return buf.String()
+	}
+
+	for i, t := range s.tables {
+		if i > 0 {
+			buf.WriteString("\n")
+		}
+		fmt.Fprintf(&buf, "[[%v]]\n", s.name)
+		for _, f := range t.fields {
+			fmt.Fprintf(&buf, "%v = %v\n", f.name, quoteTOML(f.values[0]))
+		}
+	}
+	return buf.String()
+}
+
+// quoteTOML quotes the string as a TOML basic string. Unlike Go, TOML only
+// has the escapes \b, \t, \n, \f, \r, \", \\ and \uXXXX, so other control
+// characters are written as \uXXXX.
+func quoteTOML(v string) string {
+	var buf strings.Builder
+	buf.WriteByte('"')
+	for _, r := range v {
+		switch r {
+		case '"', '\\':
+			buf.WriteByte('\\')
+			buf.WriteRune(r)
+		case '\b':
+			buf.WriteString(`\b`)
+		case '\t':
+			buf.WriteString(`\t`)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\f':
+			buf.WriteString(`\f`)
+		case '\r':
+			buf.WriteString(`\r`)
+		default:
+			if r < 0x20 || r == 0x7f {
+				fmt.Fprintf(&buf, `\u%04X`, r)
+			} else {
+				buf.WriteRune(r)
+			}
+		}
+	}
+	buf.WriteByte('"')
+	return buf.String()
+}
+
+// writeFile replaces the contents of the file, by writing to a temporary
+// file and renaming it, so that the file is never partially written
+func writeFile(path string, data []byte) error {
+	mode := os.FileMode(0644)
+	if fi, err := os.Stat(path); err == nil {
+		mode = fi.Mode().Perm()
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(mode); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
+}
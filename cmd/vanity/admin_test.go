@@ -0,0 +1,188 @@
+package main
+
+import (
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+
+	"nirenjan.org/vanity"
+)
+
+func TestConfigStore(t *testing.T) {
+	list := []vanity.Package{
+		{Name: "quote", Description: "Pithy \"sayings\"", Version: "v1.5.2"},
+		{Name: "semver"},
+		{Name: "vanity", Repo: "https://git.example.com/vanity"},
+	}
+
+	for _, c := range []struct {
+		name string
+		text string
+	}{
+		{"vanity.toml", tomlConfig},
+		{"vanity.json", jsonConfig},
+	} {
+		path := writeConfig(t, c.name, c.text)
+		fs := resetFlags(t)
+		if err := configStore(fs, path)(list); err != nil {
+			t.Fatal(err)
+		}
+
+		// The other settings are kept, and the packages are replaced
+		fs = resetFlags(t)
+		if err := loadConfig(fs, path); err != nil {
+			data, _ := ioutil.ReadFile(path)
+			t.Fatalf("Failed to load saved config %v: %v\n%s", c.name, err, data)
+		}
+
+		if opts.base != "nirenjan.org" || opts.maxConns != 2048 || !opts.index || opts.trustedProxies != "10.0.0.0/8,unix" {
+			t.Errorf("Unexpected settings in %v: base %v, max-conns %v, index %v, trusted-proxies %v",
+				c.name, opts.base, opts.maxConns, opts.index, opts.trustedProxies)
+		}
+
+		var got []vanity.Package
+		for _, p := range opts.packages {
+			got = append(got, p.Package)
+		}
+		if !reflect.DeepEqual(got, list) {
+			t.Errorf("Mismatch in saved packages in %v, expected %v, got %v", c.name, list, got)
+		}
+
+		// The TOML settings are kept as they are, along with the comments,
+		// and in JSON integers and booleans are not quoted
+		data, _ := ioutil.ReadFile(path)
+		if c.name == "vanity.toml" {
+			kept := c.text[:strings.Index(c.text, "package =")]
+			if !strings.HasPrefix(string(data), kept) {
+				t.Errorf("Mismatch in kept settings in %v, expected prefix %q, got:\n%s", c.name, kept, data)
+			}
+		} else if !strings.Contains(string(data), "2048") || strings.Contains(string(data), `"2048"`) ||
+			strings.Contains(string(data), `"true"`) {
+			t.Errorf("Mismatch in saved values in %v:\n%s", c.name, data)
+		}
+
+		// Packages with only names are saved as a list
+		fs = resetFlags(t)
+		if err := configStore(fs, path)(list[1:2]); err != nil {
+			t.Fatal(err)
+		}
+		fs = resetFlags(t)
+		if err := loadConfig(fs, path); err != nil {
+			t.Fatal(err)
+		}
+		if len(opts.packages) != 1 || opts.packages[0].Package != list[1] {
+			t.Errorf("Mismatch in saved packages in %v, expected [%v], got %v", c.name, list[1], opts.packages)
+		}
+	}
+}
+
+func TestSaveTOML(t *testing.T) {
+	quote := []vanity.Package{{Name: "quote", Description: "Tab\tbell\a \"quoted\" back\\slash \x7f"}}
+	semver := []vanity.Package{{Name: "semver"}}
+
+	checks := []struct {
+		text string
+		list []vanity.Package
+		exp  string
+	}{
+		// The array is replaced where it is, including the lines it spans
+		{"# packages\npackage = [\n\t\"a\", # first\n\t\"b\",\n]\nbase = \"x\" # base\n", semver,
+			"# packages\npackage = [\"semver\"]\nbase = \"x\" # base\n"},
+		{"base = \"x\"\npackage = [\"a\"]", semver, "base = \"x\"\npackage = [\"semver\"]\n"},
+
+		// Tables are written at the end, after the other settings
+		{"package = [\"a\"]\nbase = \"x\"\n", quote,
+			"base = \"x\"\n\n[[package]]\nname = \"quote\"\n" +
+				`description = "Tab\tbell\u0007 \"quoted\" back\\slash \u007F"` + "\n"},
+		{"base = \"x\"\n\n# Packages\n[[package]]\nname = \"a\"\n\n[[package]]\nname = \"b\"\n", semver,
+			"base = \"x\"\n\n# Packages\npackage = [\"semver\"]\n"},
+		{"base = \"x\"\n", semver, "base = \"x\"\npackage = [\"semver\"]\n"},
+		{"base = \"x\"\n[[package]]\nname = \"a\"\n", nil, "base = \"x\"\n"},
+
+		// Only the package tables are removed, up to the next table and
+		// the comments before it
+		{"base = \"x\"\n\n[[package]]\nname = \"a\"\n\n# Other tool\n[[other]]\nkey = 1\n\n[[package]]\nname = \"b\"\n\n# End\n",
+			quote, "base = \"x\"\n\n[[package]]\nname = \"quote\"\n" +
+				`description = "Tab\tbell\u0007 \"quoted\" back\\slash \u007F"` + "\n\n# Other tool\n[[other]]\nkey = 1\n\n# End\n"},
+		{"base = \"x\"\n\n[[ package ]] # first\nname = \"a\"\n\n# Other tool\n[[other]]\nkey = 1\n", semver,
+			"base = \"x\"\n\npackage = [\"semver\"]\n\n# Other tool\n[[other]]\nkey = 1\n"},
+		{"base = \"x\"\n\n[[other]]\nkey = 1\n\n[[package]]\nname = \"a\"\n", semver,
+			"base = \"x\"\n\npackage = [\"semver\"]\n\n[[other]]\nkey = 1\n"},
+	}
+
+	for _, c := range checks {
+		path := writeConfig(t, "vanity.toml", c.text)
+		data, err := saveTOML(path, c.list)
+		if err != nil {
+			t.Errorf("Failed to save %v in %q: %v", c.list, c.text, err)
+			continue
+		}
+		if string(data) != c.exp {
+			t.Errorf("Mismatch in saved config for %q, expected %q, got %q", c.text, c.exp, data)
+		}
+
+		// The saved file must be valid TOML, with the same packages
+		settings, err := parseTOML(data)
+		if err != nil {
+			t.Errorf("Invalid saved config %q: %v", data, err)
+			continue
+		}
+		for _, s := range settings {
+			if s.name != "package" {
+				continue
+			}
+			entries, _ := configPackages(path, s)
+			if len(entries) != len(c.list) || entries[0].Package != c.list[0] {
+				t.Errorf("Mismatch in saved packages, expected %v, got %v", c.list, entries)
+			}
+		}
+	}
+}
+
+func TestAdminStoreOverride(t *testing.T) {
+	path := writeConfig(t, "vanity.toml", tomlConfig)
+
+	// Packages on the command line replace the config file on a reload,
+	// so changes are rejected instead of being lost
+	fs := resetFlags(t, "-config", path, "-package", "other")
+	if err := loadConfig(fs, path); err != nil {
+		t.Fatal(err)
+	}
+	err := opts.adminStore(fs)([]vanity.Package{{Name: "semver"}})
+	if err == nil || !strings.Contains(err.Error(), "given by -package") {
+		t.Errorf("Expected the change to be rejected, got %v", err)
+	}
+	if data, _ := ioutil.ReadFile(path); string(data) != tomlConfig {
+		t.Errorf("Unexpected change to the config file:\n%s", data)
+	}
+
+	// Without a config file, there is no store
+	resetFlags(t)
+	if opts.adminStore(fs) != nil {
+		t.Errorf("Unexpected store without a config file")
+	}
+}
+
+func TestValidateAdmin(t *testing.T) {
+	checks := []struct {
+		args []string
+		exp  string
+	}{
+		{[]string{"-admin-listen", "unix:/run/vanity-admin.sock"}, ""},
+		{[]string{"-admin-listen", "127.0.0.1:8080"}, "requires -admin-token-file or -admin-client-ca"},
+		{[]string{"-admin-listen", "127.0.0.1:8080", "-admin-token-file", "token"}, ""},
+		{[]string{"-admin-listen", "127.0.0.1:8080", "-admin-cert", "cert.pem"}, "Both -admin-cert and -admin-key"},
+		{[]string{"-admin-listen", "127.0.0.1:8080", "-admin-client-ca", "ca.pem"}, "-admin-client-ca requires"},
+		{[]string{"-admin-listen", "127.0.0.1:8080", "-admin-cert", "cert.pem", "-admin-key", "key.pem",
+			"-admin-client-ca", "ca.pem"}, ""},
+	}
+
+	for _, c := range checks {
+		resetFlags(t, c.args...)
+		err := opts.validateAdmin()
+		if (err == nil) != (c.exp == "") || err != nil && !strings.Contains(err.Error(), c.exp) {
+			t.Errorf("Mismatch in error for %v, expected %#v, got %v", c.args, c.exp, err)
+		}
+	}
+}
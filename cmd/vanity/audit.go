@@ -0,0 +1,81 @@
+package main // import nirenjan.org/vanity/cmd/vanity
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"text/tabwriter"
+
+	"nirenjan.org/vanity"
+)
+
+// Flags for the audit command
+var auditJSON bool
+var auditTokenFile, auditTokenHost string
+
+func auditFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&auditJSON, "json", false, "Print the audit reports as JSON")
+	fs.StringVar(&auditTokenFile, "token-file", "", "File containing the token for the hosting provider's API (username:app-password for Bitbucket)")
+	fs.StringVar(&auditTokenHost, "token-host", "", "Host of the repositories the token is sent for (defaults to the host of -root)")
+}
+
+// runAudit checks the repositories of the configured packages, and fails if
+// any problems are found. Packages which are only found upstream are not
+// audited, since the command doesn't share the cache of a running server.
+func runAudit(server *vanity.Server, args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("Unexpected arguments %v", args)
+	}
+
+	token, err := readToken(auditTokenFile)
+	if err != nil {
+		return err
+	}
+
+	reports := server.Audit(token, auditTokenHost)
+	if len(reports) == 0 {
+		return fmt.Errorf("No packages to audit")
+	}
+
+	if auditJSON {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(reports); err != nil {
+			return err
+		}
+	} else {
+		printAudit(reports)
+	}
+
+	failed := 0
+	for _, r := range reports {
+		if !r.OK() {
+			failed++
+		}
+	}
+	if failed != 0 {
+		return fmt.Errorf("%v of %v packages have problems", failed, len(reports))
+	}
+	return nil
+}
+
+// printAudit prints the reports as a table, with a row for each problem
+func printAudit(reports []*vanity.AuditReport) {
+	tw := tabwriter.NewWriter(stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "IMPORT PATH\tREPOSITORY\tSTATUS\tDETAILS")
+	for _, r := range reports {
+		if r.OK() {
+			fmt.Fprintf(tw, "%v\t%v\tok\n", r.ImportPath, r.Repo)
+			continue
+		}
+
+		for i, f := range r.Findings {
+			if i == 0 {
+				fmt.Fprintf(tw, "%v\t%v\t%v\t%v\n", r.ImportPath, r.Repo, f.Problem, f.Detail)
+			} else {
+				fmt.Fprintf(tw, "\t\t%v\t%v\n", f.Problem, f.Detail)
+			}
+		}
+	}
+	tw.Flush()
+}
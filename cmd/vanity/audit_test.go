@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"nirenjan.org/vanity"
+)
+
+func TestAudit(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/semver" && r.URL.Path != "/semver/tree/master" {
+			http.NotFound(w, r)
+		}
+	}))
+	defer upstream.Close()
+
+	var out bytes.Buffer
+	stdout = &out
+	defer func() { stdout = os.Stdout }()
+
+	root := upstream.URL + "/"
+	args := []string{"-base", "nirenjan.org", "-root", root, "-provider", "github", "-package", "semver"}
+
+	if err := runCommand("audit", commands["audit"], args); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 || strings.Join(strings.Fields(lines[0]), " ") != "IMPORT PATH REPOSITORY STATUS DETAILS" ||
+		strings.Join(strings.Fields(lines[1]), " ") != "nirenjan.org/semver "+root+"semver ok" {
+		t.Errorf("Mismatch in output:\n%v", out.String())
+	}
+
+	// Missing repositories fail the audit
+	token := filepath.Join(t.TempDir(), "token")
+	ioutil.WriteFile(token, []byte("s3cret\n"), 0600)
+
+	out.Reset()
+	err := runCommand("audit", commands["audit"], append(args, "-package", "quote", "-json", "-token-file", token))
+	if err == nil || err.Error() != "1 of 2 packages have problems" {
+		t.Errorf("Unexpected error %v", err)
+	}
+
+	var reports []vanity.AuditReport
+	if err := json.Unmarshal(out.Bytes(), &reports); err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 2 || reports[0].Package != "quote" || len(reports[0].Findings) != 1 ||
+		reports[0].Findings[0].Problem != vanity.AuditMissing || !reports[1].OK() {
+		t.Errorf("Unexpected reports %#v", reports)
+	}
+
+	// An empty token file is rejected
+	ioutil.WriteFile(token, []byte("\n"), 0600)
+	err = runCommand("audit", commands["audit"], append(args, "-token-file", token))
+	if err == nil || !strings.Contains(err.Error(), "Empty token") {
+		t.Errorf("Expected error for empty token, got %v", err)
+	}
+}
@@ -0,0 +1,555 @@
+package main // import nirenjan.org/vanity/cmd/vanity
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// The configuration file uses the flag names as keys, e.g., `cache-ttl`, and
+// may be written in JSON or TOML, depending on the file extension. Packages
+// are given as a list of `name=repo` strings, or as a list of tables with the
+// fields of vanity.Package. In TOML, the packages are either one array, or
+// `[[package]]` sections, which must come after the other settings.
+//
+// Settings are applied in the order config file, environment, command line,
+// with later sources overriding earlier ones. The environment variable for a
+// flag is the flag name in uppercase, with `-` replaced by `_`, and prefixed
+// by `VANITY_`, e.g., `VANITY_CACHE_TTL`. VANITY_PACKAGE is a comma separated
+// list of packages, which replaces the packages from the config file.
+
+// envPrefix is the prefix for the environment variables
+const envPrefix = "VANITY_"
+
+// listFlags are the flags which accept a list of values in the config file
+var listFlags = map[string]bool{
+	"package":         true,
+	"trusted-proxies": true,
+}
+
+// setting is a single key in the configuration file, along with the line it
+// is defined on. Lists have more than one value, and arrays of tables are
+// stored in tables.
+type setting struct {
+	name   string
+	line   int
+	values []string
+	tables []table
+}
+
+// table is a set of settings, which is used to describe a package
+type table struct {
+	line   int
+	fields []setting
+}
+
+// configError is an error in the configuration file, at the given line
+type configError struct {
+	line int
+	msg  string
+}
+
+func (e *configError) Error() string {
+	return fmt.Sprintf("line %v: %v", e.line, e.msg)
+}
+
+func errorAt(line int, format string, args ...interface{}) error {
+	return &configError{line, fmt.Sprintf(format, args...)}
+}
+
+// envName returns the environment variable for the flag
+func envName(name string) string {
+	return envPrefix + strings.ToUpper(strings.Replace(name, "-", "_", -1))
+}
+
+// loadConfig applies the settings from the config file and the environment
+// to the flags which were not given on the command line.
+func loadConfig(fs *flag.FlagSet, path string) error {
+	cmdline := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		cmdline[f.Name] = true
+	})
+
+	if path == "" {
+		path = os.Getenv(envName("config"))
+	}
+
+	if path != "" {
+		settings, err := readConfig(path)
+		if err != nil {
+			return err
+		}
+
+		if err := applyConfig(fs, path, settings, cmdline); err != nil {
+			return err
+		}
+	}
+
+	return applyEnv(fs, cmdline)
+}
+
+// readConfig parses the config file, using the format given by the file
+// extension
+func readConfig(path string) ([]setting, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var settings []setting
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		settings, err = parseJSON(data)
+	case ".toml":
+		settings, err = parseTOML(data)
+	default:
+		return nil, fmt.Errorf("Unknown config format %#v, expected .json or .toml", filepath.Ext(path))
+	}
+
+	if ce, ok := err.(*configError); ok {
+		return nil, fmt.Errorf("%v:%v: %v", path, ce.line, ce.msg)
+	}
+	return settings, err
+}
+
+// applyConfig sets the flags from the settings, skipping those which were
+// given on the command line
+func applyConfig(fs *flag.FlagSet, path string, settings []setting, cmdline map[string]bool) error {
+	for _, s := range settings {
+		source := fmt.Sprintf("%v:%v", path, s.line)
+		f := fs.Lookup(s.name)
+		if f == nil || s.name == "config" {
+			return fmt.Errorf("%v: Unknown setting %#v", source, s.name)
+		}
+		if !listFlags[s.name] && (len(s.values) != 1 || len(s.tables) != 0) {
+			return fmt.Errorf("%v: Setting %#v takes a single value", source, s.name)
+		}
+		if s.name != "package" && len(s.tables) != 0 {
+			return fmt.Errorf("%v: Setting %#v does not take tables", source, s.name)
+		}
+
+		if s.name == "package" {
+			entries, err := configPackages(path, s)
+			if err != nil {
+				return err
+			}
+			if !cmdline[s.name] {
+				list := f.Value.(*packageList)
+				*list = append(*list, entries...)
+			}
+			continue
+		}
+
+		if cmdline[s.name] {
+			continue
+		}
+		if err := f.Value.Set(strings.Join(s.values, ",")); err != nil {
+			return fmt.Errorf("%v: Invalid value for %v: %v", source, s.name, err)
+		}
+	}
+
+	return nil
+}
+
+// configPackages converts the package setting into the package entries
+func configPackages(path string, s setting) ([]packageEntry, error) {
+	var entries []packageEntry
+	for _, v := range s.values {
+		entries = append(entries, parsePackage(v, fmt.Sprintf("%v:%v", path, s.line)))
+	}
+
+	for _, t := range s.tables {
+		p := packageEntry{source: fmt.Sprintf("%v:%v", path, t.line)}
+		for _, f := range t.fields {
+			if len(f.values) != 1 || len(f.tables) != 0 {
+				return nil, fmt.Errorf("%v:%v: Package field %#v takes a single value", path, f.line, f.name)
+			}
+
+			v := f.values[0]
+			switch f.name {
+			case "name":
+				p.Name = v
+			case "repo":
+				p.Repo = v
+			case "description":
+				p.Description = v
+			case "docs":
+				p.Docs = v
+			case "version":
+				p.Version = v
+			default:
+				return nil, fmt.Errorf("%v:%v: Unknown package field %#v", path, f.line, f.name)
+			}
+		}
+		entries = append(entries, p)
+	}
+
+	return entries, nil
+}
+
+// applyEnv sets the flags from the environment, skipping those which were
+// given on the command line
+func applyEnv(fs *flag.FlagSet, cmdline map[string]bool) error {
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		name := envName(f.Name)
+		v, ok := os.LookupEnv(name)
+		if !ok || err != nil || cmdline[f.Name] || f.Name == "config" {
+			return
+		}
+
+		if f.Name == "package" {
+			list := f.Value.(*packageList)
+			*list = nil
+			for _, p := range strings.Split(v, ",") {
+				if p = strings.TrimSpace(p); p != "" {
+					*list = append(*list, parsePackage(p, name))
+				}
+			}
+			return
+		}
+
+		if e := f.Value.Set(v); e != nil {
+			err = fmt.Errorf("Invalid value %#v for %v: %v", v, name, e)
+		}
+	})
+
+	return err
+}
+
+// addSetting adds the setting to the list, rejecting duplicate keys
+func addSetting(settings []setting, s setting) ([]setting, error) {
+	for _, prev := range settings {
+		if prev.name == s.name {
+			return nil, errorAt(s.line, "Duplicate setting %#v, first defined on line %v", s.name, prev.line)
+		}
+	}
+
+	return append(settings, s), nil
+}
+
+// parseJSON parses a JSON config file, which must contain a single object
+func parseJSON(data []byte) ([]setting, error) {
+	p := &jsonParser{data: data, dec: json.NewDecoder(bytes.NewReader(data))}
+	p.dec.UseNumber()
+
+	if err := p.expectDelim('{'); err != nil {
+		return nil, err
+	}
+
+	settings, err := p.object(true)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := p.dec.Token(); err != io.EOF {
+		return nil, errorAt(p.line(), "Unexpected data after the top level object")
+	}
+	return settings, nil
+}
+
+// jsonParser walks the tokens of a JSON document, keeping track of the line
+// numbers for error messages
+type jsonParser struct {
+	data []byte
+	dec  *json.Decoder
+}
+
+// line returns the line number of the current decoder offset
+func (p *jsonParser) line() int {
+	return p.lineAt(p.dec.InputOffset())
+}
+
+func (p *jsonParser) lineAt(offset int64) int {
+	if offset > int64(len(p.data)) {
+		offset = int64(len(p.data))
+	}
+	return 1 + bytes.Count(p.data[:offset], []byte("\n"))
+}
+
+// token returns the next token, converting syntax errors to config errors
+func (p *jsonParser) token() (json.Token, error) {
+	tok, err := p.dec.Token()
+	if err == nil {
+		return tok, nil
+	}
+
+	if se, ok := err.(*json.SyntaxError); ok {
+		return nil, errorAt(p.lineAt(se.Offset), "%v", se)
+	}
+	if err == io.EOF {
+		return nil, errorAt(p.lineAt(int64(len(p.data))), "Unexpected end of file")
+	}
+	return nil, errorAt(p.line(), "%v", err)
+}
+
+func (p *jsonParser) expectDelim(d json.Delim) error {
+	tok, err := p.token()
+	if err != nil {
+		return err
+	}
+	if tok != d {
+		return errorAt(p.line(), "Expected %v, got %v", d, tok)
+	}
+	return nil
+}
+
+// scalar converts a scalar token to its string value
+func (p *jsonParser) scalar(tok json.Token) (string, error) {
+	switch v := tok.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	}
+
+	return "", errorAt(p.line(), "Unexpected value %v", tok)
+}
+
+// object parses the fields of an object, after the opening brace. Lists are
+// only allowed at the top level.
+func (p *jsonParser) object(top bool) ([]setting, error) {
+	var settings []setting
+	for {
+		tok, err := p.token()
+		if err != nil {
+			return nil, err
+		}
+		if tok == json.Delim('}') {
+			return settings, nil
+		}
+
+		s := setting{name: tok.(string), line: p.line()}
+		tok, err = p.token()
+		if err != nil {
+			return nil, err
+		}
+
+		if tok == json.Delim('[') && top {
+			if err := p.list(&s); err != nil {
+				return nil, err
+			}
+		} else {
+			v, err := p.scalar(tok)
+			if err != nil {
+				return nil, err
+			}
+			s.values = []string{v}
+		}
+
+		if settings, err = addSetting(settings, s); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// list parses the elements of a list, after the opening bracket
+func (p *jsonParser) list(s *setting) error {
+	for {
+		tok, err := p.token()
+		if err != nil {
+			return err
+		}
+
+		switch tok {
+		case json.Delim(']'):
+			return nil
+
+		case json.Delim('{'):
+			t := table{line: p.line()}
+			if t.fields, err = p.object(false); err != nil {
+				return err
+			}
+			s.tables = append(s.tables, t)
+
+		default:
+			v, err := p.scalar(tok)
+			if err != nil {
+				return err
+			}
+			s.values = append(s.values, v)
+		}
+	}
+}
+
+// parseTOML parses a TOML config file. Settings are strings, integers,
+// booleans, or arrays of them, and packages may also be given as inline
+// tables, or as an array of tables.
+func parseTOML(data []byte) ([]setting, error) {
+	var doc map[string]interface{}
+	md, err := toml.Decode(string(data), &doc)
+	if pe, ok := err.(toml.ParseError); ok {
+		return nil, errorAt(pe.Position.Line, "%v", pe.Message)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	lines := tomlLines(strings.Split(string(data), "\n"))
+	var settings []setting
+	for _, key := range md.Keys() {
+		name := key[0]
+		if len(key) != 1 || doc[name] == nil {
+			continue
+		}
+
+		s := setting{name: name, line: lines.key(name, 0)}
+		switch v := doc[name].(type) {
+		case map[string]interface{}:
+			return nil, errorAt(lines.table(name, 0), "Only arrays of tables, e.g. [[package]], are supported")
+
+		case []map[string]interface{}:
+			for i, m := range v {
+				t, err := tomlTable(m, lines, lines.table(name, i))
+				if err != nil {
+					return nil, err
+				}
+				s.line = t.line
+				s.tables = append(s.tables, t)
+			}
+			if len(s.tables) != 0 {
+				s.line = s.tables[0].line
+			}
+
+		case []interface{}:
+			for _, e := range v {
+				if m, ok := e.(map[string]interface{}); ok {
+					t, err := tomlTable(m, nil, s.line)
+					if err != nil {
+						return nil, err
+					}
+					s.tables = append(s.tables, t)
+					continue
+				}
+
+				str, err := tomlScalar(e, s.line)
+				if err != nil {
+					return nil, err
+				}
+				s.values = append(s.values, str)
+			}
+
+		default:
+			str, err := tomlScalar(v, s.line)
+			if err != nil {
+				return nil, err
+			}
+			s.values = []string{str}
+		}
+
+		settings = append(settings, s)
+		delete(doc, name)
+	}
+
+	return settings, nil
+}
+
+// tomlTable converts a TOML table to a table of settings, whose fields are
+// sorted by name. If lines is not nil, the lines of the fields are looked up
+// after the table header on the given line.
+func tomlTable(m map[string]interface{}, lines tomlLines, line int) (table, error) {
+	t := table{line: line}
+	for name, v := range m {
+		f := setting{name: name, line: line}
+		if lines != nil {
+			f.line = lines.key(name, line)
+		}
+
+		if list, ok := v.([]interface{}); ok {
+			for _, e := range list {
+				str, err := tomlScalar(e, f.line)
+				if err != nil {
+					return t, err
+				}
+				f.values = append(f.values, str)
+			}
+		} else {
+			str, err := tomlScalar(v, f.line)
+			if err != nil {
+				return t, err
+			}
+			f.values = []string{str}
+		}
+
+		t.fields = append(t.fields, f)
+	}
+
+	sort.Slice(t.fields, func(i, j int) bool {
+		return t.fields[i].line < t.fields[j].line ||
+			t.fields[i].line == t.fields[j].line && t.fields[i].name < t.fields[j].name
+	})
+	return t, nil
+}
+
+// tomlScalar converts a TOML string, integer or boolean to its string value
+func tomlScalar(v interface{}, line int) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	}
+
+	return "", errorAt(line, "Unsupported value %v, expected a string, integer or boolean", v)
+}
+
+// tomlLines is the text of a TOML document split into lines, which is used
+// to find the line a key is defined on, since the decoder doesn't report it.
+type tomlLines []string
+
+// key returns the line of the first definition of the key after the given
+// line, stopping at the next table header. It returns the given line if the
+// key is not found.
+func (l tomlLines) key(name string, after int) int {
+	for i := after; i < len(l); i++ {
+		text := strings.TrimSpace(l[i])
+		if i > after && strings.HasPrefix(text, "[") {
+			break
+		}
+
+		for _, k := range []string{name, `"` + name + `"`, "'" + name + "'"} {
+			rest := strings.TrimPrefix(text, k)
+			if rest != text && strings.HasPrefix(strings.TrimSpace(rest), "=") {
+				return i + 1
+			}
+		}
+	}
+
+	return after
+}
+
+// table returns the line of the header of the nth table with the given
+// name, or 0 if it is not found
+func (l tomlLines) table(name string, n int) int {
+	for i, text := range l {
+		text = strings.TrimSpace(text)
+		if !strings.HasPrefix(text, "[") {
+			continue
+		}
+		if end := strings.Index(text, "]"); end > 0 {
+			text = strings.TrimSpace(strings.TrimLeft(text[:end], "["))
+		}
+		if strings.Trim(text, `"'`) == name {
+			if n == 0 {
+				return i + 1
+			}
+			n--
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"nirenjan.org/vanity"
+)
+
+const tomlConfig = `# Vanity configuration
+base = "nirenjan.org"
+root = 'https://github.com/nirenjan/go-'
+cache-ttl = "5m"
+max-conns = 2_048
+index = true
+trusted-proxies = [
+	"10.0.0.0/8", # internal
+	"unix",
+]
+package = [
+	"semver",
+	{ name = "vanity", repo = "https://git.example.com/vanity" },
+	{ name = "quote", description = 'Pithy "sayings"', version = "v1.5\u002E2" },
+]
+`
+
+const jsonConfig = `{
+	"base": "nirenjan.org",
+	"root": "https://github.com/nirenjan/go-",
+	"cache-ttl": "5m",
+	"max-conns": 2048,
+	"index": true,
+	"trusted-proxies": ["10.0.0.0/8", "unix"],
+	"package": [
+		"semver",
+		{"name": "vanity", "repo": "https://git.example.com/vanity"},
+		{"name": "quote", "description": "Pithy \"sayings\"", "version": "v1.5.2"}
+	]
+}
+`
+
+// resetFlags creates a new flag set with the default values
+func resetFlags(t *testing.T, args ...string) *flag.FlagSet {
+	t.Helper()
+	opts = new(options)
+	fs := flag.NewFlagSet("vanity", flag.ContinueOnError)
+	opts.defineFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return fs
+}
+
+// writeConfig writes the config to a temporary file with the given name
+func writeConfig(t *testing.T, name, text string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(path, []byte(text), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestParseConfig(t *testing.T) {
+	toml, err := parseTOML([]byte(tomlConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	js, err := parseJSON([]byte(jsonConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The values must match, only the line numbers differ
+	values := func(settings []setting) map[string][]string {
+		out := make(map[string][]string)
+		for _, s := range settings {
+			out[s.name] = s.values
+			for _, t := range s.tables {
+				for _, f := range t.fields {
+					out[s.name+"."+f.name] = append(out[s.name+"."+f.name], f.values...)
+				}
+			}
+		}
+		return out
+	}
+
+	exp := map[string][]string{
+		"base":                {"nirenjan.org"},
+		"root":                {"https://github.com/nirenjan/go-"},
+		"cache-ttl":           {"5m"},
+		"max-conns":           {"2048"},
+		"index":               {"true"},
+		"trusted-proxies":     {"10.0.0.0/8", "unix"},
+		"package":             {"semver"},
+		"package.name":        {"vanity", "quote"},
+		"package.repo":        {"https://git.example.com/vanity"},
+		"package.description": {"Pithy \"sayings\""},
+		"package.version":     {"v1.5.2"},
+	}
+
+	if v := values(toml); !reflect.DeepEqual(v, exp) {
+		t.Errorf("Mismatch in TOML config, expected %v, got %v", exp, v)
+	}
+	if v := values(js); !reflect.DeepEqual(v, exp) {
+		t.Errorf("Mismatch in JSON config, expected %v, got %v", exp, v)
+	}
+}
+
+func TestConfigErrors(t *testing.T) {
+	checks := []struct {
+		name string
+		text string
+		exp  string
+	}{
+		{"a.toml", "base = \"x\"\nroot = https://x\n", "a.toml:2: expected value"},
+		{"a.toml", "base = \"x\"\n\nbase = \"y\"\n", "a.toml:3: Key 'base' has already been defined"},
+		{"a.toml", "\n[server]\n", "a.toml:2: Only arrays of tables"},
+		{"a.toml", "base = \"x\n", "a.toml:1: strings cannot contain newlines"},
+		{"a.toml", "base = \"\\101\"\n", "a.toml:1: invalid escape"},
+		{"a.toml", "base = \"x\" root = \"y\"\n", "a.toml:1: expected a top-level item to end with a newline"},
+		{"a.toml", "base = [\"x\",\n\"y\"\n", "a.toml:2: expected a comma"},
+		{"a.toml", "cache-ttl = 1.5\n", "a.toml:1: Unsupported value 1.5"},
+		{"a.toml", "# comment\nbogus = 1\n", "a.toml:2: Unknown setting \"bogus\""},
+		{"a.toml", "base = [\"x\", \"y\"]\n", "a.toml:1: Setting \"base\" takes a single value"},
+		{"a.toml", "\ncache-ttl = \"forever\"\n", "a.toml:2: Invalid value for cache-ttl"},
+		{"a.toml", "[[package]]\nname = \"x\"\nrepository = \"y\"\n", "a.toml:3: Unknown package field \"repository\""},
+		{"a.json", "{\n\t\"base\": \"x\",\n\t\"root\": \"y\"\n\t\"vcs\": \"git\"\n}\n", "a.json:4: invalid character"},
+		{"a.json", "{\n\t\"base\": \"x\",\n\t\"index\": null\n}\n", "a.json:3: Unexpected value"},
+		{"a.json", "{\n\t\"max-conns\": \"many\"\n}\n", "a.json:2: Invalid value for max-conns"},
+		{"a.json", "{\n\t\"base\": {}\n}\n", "a.json:2: Unexpected value"},
+		{"a.json", "{\n\t\"base\": \"x\"\n", "a.json:3: Unexpected end of file"},
+		{"a.json", "[]", "a.json:1: Expected {"},
+		{"a.yaml", "base: x\n", "Unknown config format \".yaml\""},
+	}
+
+	for _, c := range checks {
+		fs := resetFlags(t)
+		path := writeConfig(t, c.name, c.text)
+
+		err := loadConfig(fs, path)
+		if err == nil {
+			t.Errorf("Expected error for %#v, got success", c.text)
+			continue
+		}
+
+		msg := strings.TrimPrefix(err.Error(), filepath.Dir(path)+string(filepath.Separator))
+		if !strings.HasPrefix(msg, c.exp) {
+			t.Errorf("Mismatch in error for %#v, expected %#v, got %#v", c.text, c.exp, msg)
+		}
+	}
+}
+
+func TestConfigPrecedence(t *testing.T) {
+	path := writeConfig(t, "vanity.toml", tomlConfig)
+
+	os.Setenv("VANITY_CACHE_TTL", "10m")
+	os.Setenv("VANITY_ROOT", "https://git.example.com/")
+	defer os.Unsetenv("VANITY_CACHE_TTL")
+	defer os.Unsetenv("VANITY_ROOT")
+
+	fs := resetFlags(t, "-root", "https://gitlab.com/nirenjan/", "-max-conns", "10")
+	if err := loadConfig(fs, path); err != nil {
+		t.Fatal(err)
+	}
+
+	if opts.base != "nirenjan.org" || opts.root != "https://gitlab.com/nirenjan/" ||
+		opts.cacheTTL != 10*time.Minute || opts.maxConns != 10 || !opts.index ||
+		opts.trustedProxies != "10.0.0.0/8,unix" {
+		t.Errorf("Unexpected settings base %v, root %v, cache-ttl %v, max-conns %v, index %v, trusted-proxies %v",
+			opts.base, opts.root, opts.cacheTTL, opts.maxConns, opts.index, opts.trustedProxies)
+	}
+
+	exp := packageList{
+		{vanity.Package{Name: "semver"}, path + ":11"},
+		{vanity.Package{Name: "vanity", Repo: "https://git.example.com/vanity"}, path + ":11"},
+		{vanity.Package{Name: "quote", Description: "Pithy \"sayings\"", Version: "v1.5.2"}, path + ":11"},
+	}
+	if !reflect.DeepEqual(opts.packages, exp) {
+		t.Errorf("Mismatch in packages, expected %v, got %v", exp, opts.packages)
+	}
+
+	// Packages from the command line replace those from the config file
+	fs = resetFlags(t, "-package", "other")
+	if err := loadConfig(fs, path); err != nil {
+		t.Fatal(err)
+	}
+	if len(opts.packages) != 1 || opts.packages[0].Name != "other" {
+		t.Errorf("Unexpected packages %v", opts.packages)
+	}
+
+	// The environment replaces the packages from the config file
+	os.Setenv("VANITY_PACKAGE", "a, b=https://git.example.com/b")
+	defer os.Unsetenv("VANITY_PACKAGE")
+	fs = resetFlags(t)
+	if err := loadConfig(fs, path); err != nil {
+		t.Fatal(err)
+	}
+	if len(opts.packages) != 2 || opts.packages[1].Repo != "https://git.example.com/b" || opts.packages[1].source != "VANITY_PACKAGE" {
+		t.Errorf("Unexpected packages %v", opts.packages)
+	}
+}
@@ -0,0 +1,82 @@
+package main // import nirenjan.org/vanity/cmd/vanity
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"nirenjan.org/vanity"
+)
+
+// Flags for the export command
+var exportDir, exportPaths string
+
+func exportFlags(fs *flag.FlagSet) {
+	fs.StringVar(&exportDir, "out", "", "Directory to write the static site to (required)")
+	fs.StringVar(&exportPaths, "paths", "", "File with additional import paths to export, one per line (- for stdin)")
+}
+
+// runExport writes the static pages for the root, the known packages, and
+// the import paths given as arguments or in the paths file
+func runExport(server *vanity.Server, args []string) error {
+	if exportDir == "" {
+		return fmt.Errorf("Missing output directory -out")
+	}
+
+	paths := args
+	if exportPaths != "" {
+		list, err := readPaths(exportPaths)
+		if err != nil {
+			return err
+		}
+		paths = append(paths, list...)
+	}
+
+	pages, err := server.Export(paths)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range pages {
+		path := filepath.Join(exportDir, filepath.FromSlash(p.Path))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(path, p.Content, 0644); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintf(stdout, "Exported %v pages to %v\n", len(pages), exportDir)
+	return nil
+}
+
+// readPaths reads the import paths from the file, one per line, skipping
+// empty lines and comments starting with #
+func readPaths(name string) ([]string, error) {
+	var r io.Reader = os.Stdin
+	if name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	var paths []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			paths = append(paths, line)
+		}
+	}
+
+	return paths, scanner.Err()
+}
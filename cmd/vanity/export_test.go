@@ -0,0 +1,50 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExport(t *testing.T) {
+	config := writeConfig(t, "vanity.toml", tomlConfig)
+	paths := writeConfig(t, "paths.txt", "# subpackages\nnirenjan.org/semver/core\n\nquote/v3\n")
+	out := t.TempDir()
+
+	err := runCommand("export", commands["export"], []string{
+		"-config", config, "-out", out, "-paths", paths, "vanity/cmd/vanity",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checks := []struct {
+		path    string
+		content string
+	}{
+		{"index.html", "<h1>nirenjan.org</h1>"},
+		{"semver/index.html", `content="nirenjan.org/semver git https://github.com/nirenjan/go-semver"`},
+		{"semver/core/index.html", `content="nirenjan.org/semver git https://github.com/nirenjan/go-semver"`},
+		{"quote/v3/index.html", `content="nirenjan.org/quote git https://github.com/nirenjan/go-quote"`},
+		{"vanity/index.html", `content="nirenjan.org/vanity git https://git.example.com/vanity"`},
+		{"vanity/cmd/vanity/index.html", `content="nirenjan.org/vanity git https://git.example.com/vanity"`},
+	}
+
+	for _, c := range checks {
+		data, err := ioutil.ReadFile(filepath.Join(out, filepath.FromSlash(c.path)))
+		if err != nil {
+			t.Errorf("Missing exported page: %v", err)
+			continue
+		}
+		if !strings.Contains(string(data), c.content) {
+			t.Errorf("Missing %v in %v:\n%s", c.content, c.path, data)
+		}
+	}
+
+	// The output directory is required
+	err = runCommand("export", commands["export"], []string{"-config", config})
+	if err == nil || !strings.Contains(err.Error(), "Missing output directory") {
+		t.Errorf("Expected error for missing output directory, got %v", err)
+	}
+}
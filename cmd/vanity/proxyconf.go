@@ -0,0 +1,41 @@
+package main // import nirenjan.org/vanity/cmd/vanity
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+
+	"nirenjan.org/vanity"
+)
+
+// Flags for the proxy-config command
+var proxyFormat, proxyOut string
+
+func proxyConfigFlags(fs *flag.FlagSet) {
+	fs.StringVar(&proxyFormat, "format", "nginx", "Reverse proxy configuration format (nginx, caddy)")
+	fs.StringVar(&proxyOut, "out", "", "File to write the configuration to (defaults to stdout)")
+}
+
+// runProxyConfig writes the reverse proxy configuration for the server
+func runProxyConfig(server *vanity.Server, args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("Unexpected arguments %v", args)
+	}
+
+	format, err := vanity.ParseProxyFormat(proxyFormat)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if err := server.ProxyConfig(&buf, format); err != nil {
+		return err
+	}
+
+	if proxyOut == "" {
+		_, err = stdout.Write(buf.Bytes())
+		return err
+	}
+	return ioutil.WriteFile(proxyOut, buf.Bytes(), 0644)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestProxyConfig(t *testing.T) {
+	config := writeConfig(t, "vanity.toml", tomlConfig)
+	out := filepath.Join(t.TempDir(), "vanity.caddy")
+
+	err := runCommand("proxy-config", commands["proxy-config"], []string{
+		"-config", config, "-format", "caddy", "-out", out,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, exp := range []string{
+		"# Caddyfile configuration generated by vanity for nirenjan.org.",
+		"# Package quote\n",
+		`content="nirenjan.org/vanity git https://git.example.com/vanity"`,
+	} {
+		if !strings.Contains(string(data), exp) {
+			t.Errorf("Missing %#v in proxy config:\n%s", exp, data)
+		}
+	}
+
+	err = runCommand("proxy-config", commands["proxy-config"], []string{"-config", config, "-format", "apache"})
+	if err == nil || !strings.Contains(err.Error(), "Unknown proxy format") {
+		t.Errorf("Expected error for unknown format, got %v", err)
+	}
+}
@@ -0,0 +1,102 @@
+package main // import nirenjan.org/vanity/cmd/vanity
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"nirenjan.org/vanity"
+)
+
+// Flags for the resolve command
+var resolveJSON bool
+
+func resolveFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&resolveJSON, "json", false, "Print the resolutions as JSON")
+}
+
+// runResolve prints how the server answers each of the import paths. It
+// fails if any of the paths is invalid, or its repository does not exist.
+func runResolve(server *vanity.Server, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("Missing import path")
+	}
+
+	// Keep the JSON output valid, by writing the errors to stderr
+	var errOut io.Writer = stdout
+	if resolveJSON {
+		errOut = os.Stderr
+	}
+
+	var results []*vanity.Resolution
+	failed := 0
+	for _, path := range args {
+		res, err := server.Resolve(path)
+		if err != nil {
+			fmt.Fprintln(errOut, err)
+			failed++
+			continue
+		}
+		if !res.Exists {
+			failed++
+		}
+		results = append(results, res)
+
+		if !resolveJSON {
+			printResolution(res)
+		}
+	}
+
+	if resolveJSON {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(results); err != nil {
+			return err
+		}
+	}
+
+	if failed != 0 {
+		return fmt.Errorf("%v of %v import paths failed to resolve", failed, len(args))
+	}
+	return nil
+}
+
+// printResolution prints the resolution as a table
+func printResolution(res *vanity.Resolution) {
+	upstream := "not checked, assumed to exist"
+	if res.Checked {
+		upstream = "not found"
+		if res.Exists {
+			upstream = "found"
+		}
+		upstream += fmt.Sprintf(" (%v %v)", res.Status, http.StatusText(res.Status))
+		if res.Cached {
+			upstream += fmt.Sprintf(", cached %.0fs ago", res.CacheAge)
+		}
+	}
+
+	tw := tabwriter.NewWriter(stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "Import path:\t%v\n", res.ImportPath)
+	fmt.Fprintf(tw, "Import prefix:\t%v\n", res.ImportPrefix)
+	fmt.Fprintf(tw, "VCS:\t%v\n", res.VcsType)
+	fmt.Fprintf(tw, "Repository:\t%v\n", res.RepoURL)
+	fmt.Fprintf(tw, "Upstream:\t%v\n", upstream)
+	fmt.Fprintf(tw, "go-import:\t%v\n", res.GoImport)
+	if res.GoSource != "" {
+		fmt.Fprintf(tw, "go-source:\t%v\n", res.GoSource)
+		fmt.Fprintf(tw, "Directory template:\t%v\n", res.DirTemplate)
+		fmt.Fprintf(tw, "File template:\t%v\n", res.FileTemplate)
+	}
+	browser := strings.Replace(res.Browser, "_", " ", -1)
+	if res.Redirect != "" {
+		browser += " to " + res.Redirect
+	}
+	fmt.Fprintf(tw, "Browser:\t%v\n", browser)
+	tw.Flush()
+	fmt.Fprintln(stdout)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"nirenjan.org/vanity"
+)
+
+func TestResolve(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/semver" {
+			http.NotFound(w, r)
+		}
+	}))
+	defer upstream.Close()
+
+	var out bytes.Buffer
+	stdout = &out
+	defer func() { stdout = os.Stdout }()
+
+	root := upstream.URL + "/"
+	args := []string{"-base", "nirenjan.org", "-root", root, "-provider", "github"}
+
+	err := runCommand("resolve", commands["resolve"], append(args, "nirenjan.org/semver/core"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, exp := range []string{
+		"Import path:         nirenjan.org/semver/core\n",
+		"Import prefix:       nirenjan.org/semver\n",
+		"Repository:          " + root + "semver\n",
+		"Upstream:            found (200 OK)\n",
+		"Directory template:  tree/master{/dir}\n",
+		"Browser:             redirect to " + root + "semver\n",
+	} {
+		if !strings.Contains(out.String(), exp) {
+			t.Errorf("Missing %#v in output:\n%v", exp, out.String())
+		}
+	}
+
+	// Missing repositories and invalid paths fail
+	out.Reset()
+	err = runCommand("resolve", commands["resolve"], append(args, "-json", "semver", "quote", "github.com/x/y"))
+	if err == nil || err.Error() != "2 of 3 import paths failed to resolve" {
+		t.Errorf("Unexpected error %v", err)
+	}
+
+	var results []vanity.Resolution
+	if err := json.Unmarshal(out.Bytes(), &results); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || !results[0].Exists || results[1].Exists || results[1].Status != http.StatusNotFound {
+		t.Errorf("Unexpected results %#v", results)
+	}
+}
@@ -2,62 +2,216 @@ package main // import nirenjan.org/vanity/cmd/vanity
 
 import (
 	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
 	"net"
 	"os"
 	"os/signal"
+	"strings"
+	"sync"
 	"syscall"
+	"time"
 
 	"nirenjan.org/vanity"
 )
 
-// Flags for server
-var base, root, redirect, provider, vcs, rootRedirect, webRoot string
-var listenTCP, listenUnix string
-var noQueryRemote bool
+// options are the settings of the server, from the command line, config
+// file and environment. A reload reads them into a new value, so the options
+// of the running server are not changed unless the reload succeeds.
+type options struct {
+	configFile string
+
+	base, root, redirect, provider, vcs string
+	rootRedirect, rootRepo, webRoot     string
+	notFoundRedirect                    string
+	preserveQuery                       bool
+
+	redirectCode, rootRedirectCode, notFoundRedirectCode int
+
+	listenTCP, listenUnix      string
+	noQueryRemote              bool
+	maxHeaderBytes, maxConns   int
+	accessLog, accessLogFormat string
+	trustedProxies             string
+	templateFile               string
+	index, landing             bool
+	landingDelay               time.Duration
+	packages                   packageList
+
+	readHeaderTimeout, readTimeout, writeTimeout, idleTimeout time.Duration
+	cacheTTL, cacheMeta, cacheRedirect, cacheNotFound         time.Duration
+
+	metricsPath, metricsListen string
+	healthPath, apiPath        string
+
+	// Flags for the admin API
+	adminListen, adminTokenFile, adminCert, adminKey, adminClientCA string
+}
+
+// opts are the options of the running server
+var opts = new(options)
+
+// packageEntry is a known package, along with where it was defined
+type packageEntry struct {
+	vanity.Package
+	source string
+}
+
+// parsePackage parses a package of the form name or name=repo
+func parsePackage(v, source string) packageEntry {
+	kv := strings.SplitN(v, "=", 2)
+	p := packageEntry{source: source}
+	p.Name = kv[0]
+	if len(kv) == 2 {
+		p.Repo = kv[1]
+	}
+
+	return p
+}
+
+// packageList is a flag.Value collecting the -package arguments
+type packageList []packageEntry
+
+func (p *packageList) String() string {
+	return fmt.Sprint(*p)
+}
+
+// Set adds a package of the form name or name=repo
+func (p *packageList) Set(v string) error {
+	*p = append(*p, parsePackage(v, "-package"))
+	return nil
+}
+
+// stdout is where the subcommands write their output
+var stdout io.Writer = os.Stdout
+
+// logFile is the access log file, if logging to a file
+var logFile *vanity.LogFile
+
+// reloadMu serializes the reloads with the admin API saving the packages to
+// the config file, so that a reload never reads the file while it is being
+// replaced, and the packages saved by the admin API are never lost
+var reloadMu sync.Mutex
+
+// command is a subcommand of vanity, which is configured with the same flags,
+// config file and environment as the server, along with its own flags
+type command struct {
+	// usage describes the arguments after the flags
+	usage string
+
+	// flags registers the flags of the command, and may be nil
+	flags func(fs *flag.FlagSet)
+
+	// run runs the command with the server built from the configuration,
+	// and the remaining arguments
+	run func(server *vanity.Server, args []string) error
+}
+
+// commands are the subcommands, indexed by name
+var commands = map[string]command{
+	"audit":        {"", auditFlags, runAudit},
+	"export":       {"[import paths]", exportFlags, runExport},
+	"proxy-config": {"", proxyConfigFlags, runProxyConfig},
+	"resolve":      {"import paths...", resolveFlags, runResolve},
+}
+
+// runCommand parses the arguments of the subcommand, builds the server from
+// the configuration, and runs the command
+func runCommand(name string, cmd command, args []string) error {
+	fs := flag.NewFlagSet("vanity "+name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: vanity %v [flags] %v\n", name, cmd.usage)
+		fs.PrintDefaults()
+	}
+	o := new(options)
+	o.defineFlags(fs)
+	if cmd.flags != nil {
+		cmd.flags(fs)
+	}
+	fs.Parse(args)
+
+	if err := loadConfig(fs, o.configFile); err != nil {
+		return err
+	}
+	if err := o.validateArgs(); err != nil {
+		return err
+	}
+
+	server, err := o.buildServer()
+	if err != nil {
+		return err
+	}
+
+	return cmd.run(server, fs.Args())
+}
 
 func main() {
-	flag.StringVar(&base, "base", "", "Base URL for vanity server (required)")
-	flag.StringVar(&root, "root", "", "Root URL for VCS host (required)")
-	flag.StringVar(&redirect, "redirect", "", "Redirect URL for browsers")
-	flag.StringVar(&provider, "provider", "", "VCS Provider")
-	flag.StringVar(&vcs, "vcs", "", "VCS type (git, subversion, etc.)")
-	flag.StringVar(&rootRedirect, "root-redirect", "", "Redirect for requests to base URL")
-
-	flag.StringVar(&webRoot, "web-root", "", "Directory containing the .well-known folder")
-	flag.StringVar(&listenTCP, "listen-tcp", "", "Port to listen on for HTTP server")
-	flag.StringVar(&listenUnix, "listen-unix", "", "Socket to listen on for HTTP server")
-	flag.BoolVar(&noQueryRemote, "no-query-remote", false, "Don't query the remote server for repo presence")
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			logger := log.New(os.Stderr, "vanity "+os.Args[1]+": ", 0)
+			if err := runCommand(os.Args[1], cmd, os.Args[2:]); err != nil {
+				logger.Fatal(err)
+			}
+			return
+		}
+	}
+
+	opts.defineFlags(flag.CommandLine)
 	flag.Parse()
 
 	logger := log.New(os.Stderr, "vanity: ", 0)
-	validateArgs(logger)
+	if err := loadConfig(flag.CommandLine, opts.configFile); err != nil {
+		logger.Fatal(err)
+	}
+	if err := opts.validateArgs(); err != nil {
+		logger.Fatal(err)
+	}
 
-	server := spawnServer(logger)
-	configureServer(logger, server)
+	server := opts.spawnServer(logger)
 
-	log.SetFlags(0)
-	log.Println("Starting vanity server")
-	if listenTCP != "" {
-		log.Println("Listening on", listenTCP)
-	} else if listenUnix != "" {
-		log.Println("Listening on", listenUnix)
+	banner := log.New(os.Stderr, "", 0)
+	banner.Println("Starting vanity server")
+	if opts.listenTCP != "" {
+		banner.Println("Listening on", opts.listenTCP)
+	} else if opts.listenUnix != "" {
+		banner.Println("Listening on", opts.listenUnix)
 	}
-	log.Print(server)
-	log.SetFlags(log.LstdFlags)
+	banner.Print(server)
 
-	// Handle os.Interrupt
+	// Handle os.Interrupt, and reopen the access log and reload the
+	// configuration on SIGHUP. The sockets are saved, since a reload may
+	// change the settings.
+	socket := opts.listenUnix
+	adminSocket := strings.TrimPrefix(opts.adminListen, "unix:")
+	if adminSocket == opts.adminListen {
+		adminSocket = ""
+	}
 	go func() {
-		ch := make(chan os.Signal)
+		ch := make(chan os.Signal, 1)
+
+		signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
 
-		signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM)
+		for sig := range ch {
+			if sig == syscall.SIGHUP {
+				if logFile != nil {
+					if err := logFile.Reopen(); err != nil {
+						logger.Print(err)
+					}
+				}
+				reloadServer(logger, server)
+				continue
+			}
 
-		select {
-		case <-ch:
-			if listenUnix != "" {
-				os.Remove(listenUnix)
+			if socket != "" {
+				os.Remove(socket)
+			}
+			if adminSocket != "" {
+				os.Remove(adminSocket)
 			}
 			server.ShutDown()
+			return
 		}
 	}()
 
@@ -66,68 +220,259 @@ func main() {
 	}
 }
 
-func validateArgs(logger *log.Logger) {
-	if base == "" {
-		logger.Fatal("Missing Base URL on command line")
+// defineFlags registers the command line flags in the flag set
+func (o *options) defineFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.configFile, "config", "", "Config file in JSON or TOML format")
+	fs.StringVar(&o.base, "base", "", "Base URL for vanity server (required)")
+	fs.StringVar(&o.root, "root", "", "Root URL for VCS host (required)")
+	fs.StringVar(&o.redirect, "redirect", "", "Redirect URL for browsers")
+	fs.StringVar(&o.provider, "provider", "", "VCS Provider")
+	fs.StringVar(&o.vcs, "vcs", "", "VCS type (git, subversion, etc.)")
+	fs.StringVar(&o.rootRedirect, "root-redirect", "", "Redirect for requests to base URL")
+	fs.StringVar(&o.rootRepo, "root-repo", "", "Repository for the module at the base URL itself")
+	fs.StringVar(&o.notFoundRedirect, "not-found-redirect", "", "Redirect for browsers when the package does not exist")
+	fs.IntVar(&o.redirectCode, "redirect-code", 302, "HTTP status code for package redirects (301, 302, 307, 308)")
+	fs.IntVar(&o.rootRedirectCode, "root-redirect-code", 302, "HTTP status code for the base URL redirect")
+	fs.IntVar(&o.notFoundRedirectCode, "not-found-redirect-code", 302, "HTTP status code for the missing package redirect")
+	fs.BoolVar(&o.preserveQuery, "preserve-query", false, "Forward the request query parameters to browser redirects")
+
+	fs.BoolVar(&o.index, "index", false, "Serve an index of the known packages at the base URL")
+	fs.BoolVar(&o.landing, "landing", false, "Serve a landing page to browsers instead of redirecting")
+	fs.DurationVar(&o.landingDelay, "landing-delay", 0, "Delay before the landing page redirects (0 to only show a link)")
+	fs.Var(&o.packages, "package", "Known package as name or name=repo (may be repeated)")
+	fs.StringVar(&o.templateFile, "template", "", "HTML template file for go-get responses")
+	fs.StringVar(&o.webRoot, "web-root", "", "Directory containing the .well-known folder")
+	fs.StringVar(&o.listenTCP, "listen-tcp", "", "Port to listen on for HTTP server")
+	fs.StringVar(&o.listenUnix, "listen-unix", "", "Socket to listen on for HTTP server")
+	fs.BoolVar(&o.noQueryRemote, "no-query-remote", false, "Don't query the remote server for repo presence")
+	fs.DurationVar(&o.cacheTTL, "cache-ttl", 0, "Duration to cache the results of querying the remote")
+	fs.DurationVar(&o.cacheMeta, "cache-meta", time.Hour, "Cache-Control max-age for go-import pages (negative to disable)")
+	fs.DurationVar(&o.cacheRedirect, "cache-redirect", time.Hour, "Cache-Control max-age for browser redirects (negative to disable)")
+	fs.DurationVar(&o.cacheNotFound, "cache-not-found", time.Minute, "Cache-Control max-age for missing packages (negative to disable)")
+	fs.StringVar(&o.accessLog, "access-log", "", "File to write the access log to (defaults to stderr)")
+	fs.StringVar(&o.accessLogFormat, "access-log-format", "text", "Access log format (text, common, combined, json)")
+
+	fs.StringVar(&o.healthPath, "health-path", "", "Path prefix for the /live and /ready health endpoints")
+	fs.StringVar(&o.apiPath, "api-path", "", "Path prefix for the JSON API, e.g. /_api")
+	fs.StringVar(&o.metricsPath, "metrics-path", "", "Path to serve Prometheus metrics on (default /metrics with -metrics-listen)")
+	fs.StringVar(&o.metricsListen, "metrics-listen", "", "Address to serve the metrics endpoint on, instead of the main listener")
+	fs.StringVar(&o.adminListen, "admin-listen", "", "Address (host:port or unix:path) to serve the admin API on")
+	fs.StringVar(&o.adminTokenFile, "admin-token-file", "", "File containing the bearer token for the admin API")
+	fs.StringVar(&o.adminCert, "admin-cert", "", "TLS certificate file for the admin API")
+	fs.StringVar(&o.adminKey, "admin-key", "", "TLS key file for the admin API")
+	fs.StringVar(&o.adminClientCA, "admin-client-ca", "", "CA file to verify admin API client certificates")
+	fs.StringVar(&o.trustedProxies, "trusted-proxies", "", "Comma separated list of trusted proxy CIDRs, or unix")
+
+	fs.DurationVar(&o.readHeaderTimeout, "read-header-timeout", 5*time.Second, "Timeout for reading request headers")
+	fs.DurationVar(&o.readTimeout, "read-timeout", 10*time.Second, "Timeout for reading the entire request")
+	fs.DurationVar(&o.writeTimeout, "write-timeout", 10*time.Second, "Timeout for writing the response")
+	fs.DurationVar(&o.idleTimeout, "idle-timeout", 60*time.Second, "Timeout for idle keep-alive connections")
+	fs.IntVar(&o.maxHeaderBytes, "max-header-bytes", 1<<14, "Maximum size of request headers")
+	fs.IntVar(&o.maxConns, "max-conns", 1024, "Maximum number of concurrent connections (0 for unlimited)")
+}
+
+func (o *options) validateArgs() error {
+	if o.base == "" {
+		return fmt.Errorf("Missing Base URL on command line or in config")
+	}
+
+	if o.root == "" {
+		return fmt.Errorf("Missing Root URL on command line or in config")
+	}
+
+	if o.listenTCP != "" && o.listenUnix != "" {
+		return fmt.Errorf("Conflicting arguments -listen-tcp and -listen-unix")
+	}
+
+	return o.validateAdmin()
+}
+
+// reloadServer reads the command line, config file and environment again
+// into new options, and replaces the configuration of the running server.
+// If the new configuration is invalid, the error is logged and neither the
+// options nor the server are changed.
+func reloadServer(logger *log.Logger, server *vanity.Server) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	o := new(options)
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	o.defineFlags(fs)
+
+	err := fs.Parse(os.Args[1:])
+	if err == nil {
+		err = loadConfig(fs, o.configFile)
+	}
+	if err == nil {
+		err = o.validateArgs()
 	}
 
-	if root == "" {
-		logger.Fatal("Missing Root URL on command line")
+	var next *vanity.Server
+	if err == nil {
+		next, err = o.buildServer()
 	}
 
-	if listenTCP != "" && listenUnix != "" {
-		logger.Fatal("Conflicting arguments -listen-tcp and -listen-unix")
+	if err != nil {
+		logger.Print("Reload failed, keeping the current configuration: ", err)
+		return
 	}
 
+	opts = o
+	server.Reload(next)
+	logger.Print("Reloaded configuration")
 }
 
-func spawnServer(logger *log.Logger) *vanity.Server {
-	server, err := vanity.NewServer(base, root, redirect)
+// buildServer creates a server with the settings, but without any listeners
+// or access log. It is used at startup, and to reload the configuration.
+func (o *options) buildServer() (*vanity.Server, error) {
+	server, err := vanity.NewServer(o.base, o.root, o.redirect)
 	if err != nil {
-		logger.Fatal(err)
+		return nil, err
+	}
+
+	if o.rootRedirect != "" {
+		server.RootRedirect(o.rootRedirect)
+	}
+	server.RootRepo(o.rootRepo)
+	server.NotFoundRedirect(o.notFoundRedirect)
+	if err := server.RedirectCodes(o.redirectCode, o.rootRedirectCode, o.notFoundRedirectCode); err != nil {
+		return nil, err
 	}
+	server.PreserveQuery(o.preserveQuery)
+
+	if err := o.configureServer(server); err != nil {
+		return nil, err
+	}
+	return server, nil
+}
 
-	if rootRedirect != "" {
-		server.RootRedirect(rootRedirect)
+func (o *options) spawnServer(logger *log.Logger) *vanity.Server {
+	server, err := o.buildServer()
+	if err != nil {
+		logger.Fatal(err)
 	}
 
-	if listenTCP != "" {
-		l, err := net.Listen("tcp", listenTCP)
+	if o.listenTCP != "" {
+		l, err := net.Listen("tcp", o.listenTCP)
 		if err != nil {
 			logger.Fatal(err)
 		}
 		server.Listen(l)
 	}
 
-	if listenUnix != "" {
-		l, err := net.Listen("unix", listenUnix)
+	if o.listenUnix != "" {
+		l, err := net.Listen("unix", o.listenUnix)
 		if err != nil {
 			logger.Fatal(err)
 		}
 		server.Listen(l)
 	}
 
-	return server
-}
-
-func configureServer(logger *log.Logger, server *vanity.Server) {
-	if webRoot != "" {
-		if err := server.WebRoot(webRoot); err != nil {
+	if o.metricsListen != "" {
+		l, err := net.Listen("tcp", o.metricsListen)
+		if err != nil {
 			logger.Fatal(err)
 		}
+		if o.metricsPath == "" {
+			o.metricsPath = "/metrics"
+		}
+		server.ServeMetrics(o.metricsPath, l)
+	} else if o.metricsPath != "" {
+		server.ServeMetrics(o.metricsPath, nil)
 	}
 
-	if provider != "" {
-		if err := server.Repo().SetProvider(provider); err != nil {
+	if o.adminListen != "" {
+		token, err := readToken(o.adminTokenFile)
+		if err != nil {
 			logger.Fatal(err)
 		}
+		l, err := o.listenAdmin()
+		if err != nil {
+			logger.Fatal(err)
+		}
+		server.ServeAdmin(l, token)
+
+		if store := o.adminStore(flag.CommandLine); store != nil {
+			server.AdminStore(store)
+		} else {
+			logger.Print("No config file, changes made through the admin API will not be saved")
+		}
 	}
 
-	if vcs != "" {
-		if err := server.Repo().SetType(vcs); err != nil {
+	format, err := vanity.ParseLogFormat(o.accessLogFormat)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	if o.accessLog != "" {
+		logFile, err = vanity.OpenLogFile(o.accessLog)
+		if err != nil {
 			logger.Fatal(err)
 		}
+		server.AccessLog(vanity.NewAccessLogger(logFile, format))
+	} else {
+		server.AccessLog(vanity.NewAccessLogger(os.Stderr, format))
+	}
+
+	return server
+}
+
+func (o *options) configureServer(server *vanity.Server) error {
+	if o.webRoot != "" {
+		if err := server.WebRoot(o.webRoot); err != nil {
+			return err
+		}
+	}
+
+	if o.provider != "" {
+		if err := server.Repo().SetProvider(o.provider); err != nil {
+			return err
+		}
+	}
+
+	if o.vcs != "" {
+		if err := server.Repo().SetType(o.vcs); err != nil {
+			return err
+		}
+	}
+
+	for _, p := range o.packages {
+		if err := server.AddPackage(p.Package); err != nil {
+			return fmt.Errorf("%v: %v", p.source, err)
+		}
+	}
+	server.Index(o.index)
+	server.LandingPages(o.landing, o.landingDelay)
+
+	if o.templateFile != "" {
+		if err := server.TemplateFile(o.templateFile); err != nil {
+			return err
+		}
+	}
+
+	server.QueryRemote(!o.noQueryRemote)
+	server.CacheTTL(o.cacheTTL)
+	server.CacheLifetimes(o.cacheMeta, o.cacheRedirect, o.cacheNotFound)
+	server.HealthChecks(o.healthPath)
+	server.API(o.apiPath)
+
+	if o.trustedProxies != "" {
+		if err := server.TrustedProxies(strings.Split(o.trustedProxies, ",")); err != nil {
+			return err
+		}
+	}
+
+	if err := server.Timeouts(o.readHeaderTimeout, o.readTimeout, o.writeTimeout, o.idleTimeout); err != nil {
+		return err
+	}
+
+	if err := server.MaxHeaderBytes(o.maxHeaderBytes); err != nil {
+		return err
+	}
+
+	if err := server.MaxConns(o.maxConns); err != nil {
+		return err
 	}
 
-	server.QueryRemote(!noQueryRemote)
+	return nil
 }
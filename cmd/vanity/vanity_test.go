@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestReloadServer(t *testing.T) {
+	path := writeConfig(t, "vanity.toml", tomlConfig)
+	args := os.Args
+	defer func() { os.Args = args }()
+	os.Args = []string{"vanity", "-config", path}
+
+	fs := resetFlags(t, os.Args[1:]...)
+	if err := loadConfig(fs, path); err != nil {
+		t.Fatal(err)
+	}
+	server, err := opts.buildServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	logger := log.New(&buf, "", 0)
+
+	// A valid config replaces the options and the packages
+	if err := ioutil.WriteFile(path, []byte("base = \"nirenjan.org\"\nroot = \"https://github.com/nirenjan/\"\npackage = [\"semver\"]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	prev := opts
+	reloadServer(logger, server)
+	if opts == prev || opts.root != "https://github.com/nirenjan/" || len(opts.packages) != 1 {
+		t.Errorf("Mismatch in options after reload, got root %v, packages %v", opts.root, opts.packages)
+	}
+	if p := server.Packages(); len(p) != 1 || p[0].Name != "semver" {
+		t.Errorf("Mismatch in packages after reload, got %v", p)
+	}
+
+	// An invalid config leaves both unchanged, even if some of the settings
+	// were applied before the error
+	if err := ioutil.WriteFile(path, []byte("base = \"example.com\"\npackage = [\"quote\"]\ncache-ttl = \"forever\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	prev = opts
+	reloadServer(logger, server)
+	if opts != prev || opts.base != "nirenjan.org" || len(opts.packages) != 1 || opts.packages[0].Name != "semver" {
+		t.Errorf("Unexpected options after failed reload, got base %v, packages %v", opts.base, opts.packages)
+	}
+	if p := server.Packages(); len(p) != 1 || p[0].Name != "semver" {
+		t.Errorf("Unexpected packages after failed reload, got %v", p)
+	}
+	if !strings.Contains(buf.String(), "Reload failed") {
+		t.Errorf("Expected the failed reload to be logged, got %q", buf.String())
+	}
+}
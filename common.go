@@ -6,22 +6,24 @@ import (
 	"log"
 	"net/http"
 	"strings"
+	"time"
 )
 
 // repoBase returns the first segment of the requested URL. It does so
-// by splitting on `/`, and returning the first result.
+// by splitting on `/`, and returning the first result, without any
+// version suffix.
 func repoBase(url string) string {
-	return strings.Split(strings.TrimPrefix(url, "/"), "/")[0]
+	base := strings.Split(strings.TrimPrefix(url, "/"), "/")[0]
+	return strings.Split(base, "@")[0]
 }
 
 // checkUpstream verifies that the package is available on the remote server
-func (s *Server) checkUpstream(module string) (bool, int) {
-	if !s.queryRemote {
+func (s *Server) checkUpstream(c *config, module string) (bool, int) {
+	if !c.queryRemote {
 		return true, http.StatusOK
 	}
 
-	base := repoBase(module)
-	upstream := s.repo.root + base
+	upstream := c.repoURL(repoBase(module))
 
 	// Head will follow up to 10 redirects, so no need to worry about
 	// it here.
@@ -36,14 +38,64 @@ func (s *Server) checkUpstream(module string) (bool, int) {
 	return resp.StatusCode == http.StatusOK, resp.StatusCode
 }
 
+// upstreamExists checks if the package is available on the remote server,
+// using the cached result if there is one. It records the cache status and
+// the upstream latency in the request info.
+func (s *Server) upstreamExists(r *http.Request, module string) bool {
+	c := s.requestConfig(r)
+	if !c.queryRemote {
+		return true
+	}
+
+	info := getRequestInfo(r)
+	e, cached, latency := s.lookupUpstream(c, module)
+	info.cacheHit = cached
+	info.upstream = latency
+
+	return e.exists
+}
+
+// lookupUpstream returns the result of the upstream check for the module,
+// whether it was found in the cache, and the time taken to query the
+// upstream if it was not.
+func (s *Server) lookupUpstream(c *config, module string) (cacheEntry, bool, time.Duration) {
+	base := repoBase(module)
+	e, ok := s.cache.get(base)
+	s.metrics.observeCache(ok)
+	if ok {
+		return e, true, 0
+	}
+
+	start := time.Now()
+	exists, code := s.checkUpstream(c, module)
+	latency := time.Since(start)
+	s.metrics.observeUpstream(latency, exists, code)
+	s.health.observeUpstream(code)
+
+	e = cacheEntry{exists: exists, code: code, checked: time.Now()}
+	s.cache.put(base, e)
+	return e, false, latency
+}
+
 // getRedirect gets the URL to redirect to
 // If s.Redirect and s.Repo are the same, we cannot use the full request
-// and must use the base only.
-func (s *Server) getRedirect(module string) string {
-	base := repoBase(module)
-	if s.redirect == s.repo.root {
-		return s.repo.root + base
+// and must use the base only, unless a version was requested, in which
+// case it links to the source at that version. If the redirect contains
+// placeholders, they are replaced with the values from the request.
+func (c *config) getRedirect(module, rawQuery string) string {
+	if hasPlaceholders(c.redirect) {
+		return c.expandRedirect(c.redirect, module, rawQuery)
+	}
+
+	m := parseModulePath(module)
+	if c.redirect == c.repo.root {
+		if m.version != "" {
+			return c.repo.sourceURL(c.repoURL(m.pkg), m.version, m.sourceDir())
+		}
+		return c.repoURL(m.pkg)
 	}
 
-	return s.redirect + module
+	// Documentation sites like pkg.go.dev expect the version after the
+	// full import path
+	return c.redirect + "/" + m.path() + m.atVersion()
 }
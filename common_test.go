@@ -19,6 +19,8 @@ func TestRepoBase(t *testing.T) {
 		{"semver", "semver"},
 		{"/semver/core", "semver"},
 		{"/semver", "semver"},
+		{"/semver@v1.2.3", "semver"},
+		{"/semver@v1.2.3/core", "semver"},
 		{"", ""},
 	}
 
@@ -47,7 +49,7 @@ func TestGetRedirect(t *testing.T) {
 	for _, c := range checks {
 		s, _ := NewServer("nirenjan.org", c.root, c.redirect)
 
-		if res := s.getRedirect(c.module); c.exp != res {
+		if res := s.config().getRedirect(c.module, ""); c.exp != res {
 			t.Errorf("Mismatch in Server.getRedirect, expected %#v, got %#v",
 				c.exp, res)
 		}
@@ -106,7 +108,7 @@ func TestCheckUpstream(t *testing.T) {
 	s.client.Timeout = time.Second / 10
 
 	for _, c := range checks {
-		ok, code := s.checkUpstream(c.query)
+		ok, code := s.checkUpstream(s.config(), c.query)
 		if ok != c.ok || code != c.code {
 			t.Errorf("Mismatch in Server.checkUpstream(%v); expected (%v, %v), got (%v, %v)",
 				c.query, c.ok, c.code, ok, code)
@@ -114,9 +116,9 @@ func TestCheckUpstream(t *testing.T) {
 	}
 
 	// Try disabling queryRemote
-	s.queryRemote = false
+	s.QueryRemote(false)
 	for _, c := range checks {
-		ok, code := s.checkUpstream(c.query)
+		ok, code := s.checkUpstream(s.config(), c.query)
 		if !ok || code != http.StatusOK {
 			t.Errorf("Mismatch in Server.checkUpstream(%v); queryRemote = false, got (%v, %v)",
 				c.query, ok, code)
@@ -0,0 +1,56 @@
+// Copyright 2019 Nirenjan Krishnan. All rights reserved.
+
+package vanity
+
+import (
+	"net/http"
+	"time"
+)
+
+// clone returns a copy of the configuration, which can be modified without
+// affecting the original.
+func (c *config) clone() *config {
+	n := *c
+	n.packages = make(map[string]Package, len(c.packages))
+	for name, p := range c.packages {
+		n.packages[name] = p
+	}
+
+	return &n
+}
+
+// config returns the current configuration of the server
+func (s *Server) config() *config {
+	return s.cfg.Load().(*config)
+}
+
+// update applies the change to a copy of the current configuration, and
+// then replaces the current configuration with the copy. If the change
+// fails, the current configuration is kept. The modification time is moved
+// forward, so that conditional requests for pages of the old configuration
+// are not answered with a 304.
+func (s *Server) update(change func(c *config) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	prev := s.config()
+	c := prev.clone()
+	if err := change(c); err != nil {
+		return err
+	}
+
+	c.replaced = prev.modified
+	c.modified = time.Now()
+	s.cfg.Store(c)
+	return nil
+}
+
+// requestConfig returns the configuration for the request, which is the
+// configuration at the time the request was received.
+func (s *Server) requestConfig(r *http.Request) *config {
+	if c := getRequestInfo(r).cfg; c != nil {
+		return c
+	}
+
+	return s.config()
+}
@@ -0,0 +1,221 @@
+// Copyright 2019 Nirenjan Krishnan. All rights reserved.
+
+package vanity
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestUpdateConfig(t *testing.T) {
+	s, _ := NewServer("nirenjan.org", "https://github.com/nirenjan/go-", "")
+	before := s.config()
+
+	s.RootRedirect("https://example.com/")
+	if err := s.Repo().SetProvider("github"); err != nil {
+		t.Fatal(err)
+	}
+	s.AddPackage(Package{Name: "semver"})
+
+	// The previous configuration is never modified
+	if before.rootRedirect != "https://github.com/nirenjan/go-" || before.repo.provider != "" || len(before.packages) != 0 {
+		t.Errorf("Previous configuration was modified %#v", before)
+	}
+
+	c := s.config()
+	if c.rootRedirect != "https://example.com/" || c.repo.provider != "github" || len(c.packages) != 1 {
+		t.Errorf("Configuration was not updated %#v", c)
+	}
+
+	// Failed changes keep the current configuration
+	if err := s.Repo().SetTemplates("tree", "blob"); err == nil {
+		t.Errorf("Expected error for invalid file template")
+	}
+	if err := s.TrustedProxies([]string{"bogus"}); err == nil {
+		t.Errorf("Expected error for invalid proxy")
+	}
+	if s.config() != c {
+		t.Errorf("Configuration changed after failed updates")
+	}
+
+	// A standalone Vcs is modified directly
+	var v Vcs
+	v.SetProvider("gitlab")
+	if v.provider != "gitlab" || v.vcsType != "git" {
+		t.Errorf("Standalone Vcs was not modified %#v", v)
+	}
+}
+
+func TestRequestConfig(t *testing.T) {
+	s, _ := NewServer("nirenjan.org", "https://github.com/nirenjan/go-", "")
+	s.AccessLog(nil)
+
+	var seen *config
+	handler := s.getHandler("/", func(w http.ResponseWriter, r *http.Request) {
+		seen = s.requestConfig(r)
+		s.RootRedirect("https://example.com/")
+
+		// The request keeps the configuration it started with
+		if s.requestConfig(r) != seen || seen.rootRedirect == "https://example.com/" {
+			t.Errorf("Configuration changed during the request")
+		}
+	})
+
+	want := s.config()
+	handler(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if seen != want {
+		t.Errorf("Request did not use the configuration at the time it was received")
+	}
+	if s.config().rootRedirect != "https://example.com/" {
+		t.Errorf("Configuration was not updated")
+	}
+}
+
+// TestConcurrentConfig changes the configuration while requests are being
+// handled. It is meant to be run with the race detector.
+func TestConcurrentConfig(t *testing.T) {
+	s, _ := NewServer("nirenjan.org", "https://github.com/nirenjan/go-", "")
+	s.QueryRemote(false)
+	s.AccessLog(nil)
+	s.CacheTTL(time.Minute)
+	s.API("/_api")
+	s.HealthChecks("/_health")
+
+	// There is no listener, so the server must be marked as serving for
+	// the readiness check to pass
+	s.health.setServing(true)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.getHandler("/", s.handleGeneric))
+	mux.HandleFunc("/_api/resolve", s.getHandler("/_api/resolve", s.handleResolve))
+	mux.HandleFunc("/_health/ready", s.getHandler("/_health/ready", s.handleReady))
+
+	paths := []string{
+		"/semver?go-get=1", "/semver/v2/core", "/", "/?go-get=1",
+		"/_api/resolve?path=nirenjan.org/semver", "/_health/ready",
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				for _, p := range paths {
+					rr := httptest.NewRecorder()
+					mux.ServeHTTP(rr, httptest.NewRequest("GET", p, nil))
+					if rr.Code >= 500 {
+						t.Errorf("Unexpected status %v for %v", rr.Code, p)
+					}
+				}
+				s.Packages()
+				_ = s.String()
+			}
+		}()
+	}
+
+	for i := 0; i < 50; i++ {
+		s.RootRedirect(fmt.Sprintf("https://example.com/%v", i))
+		s.NotFoundRedirect("https://example.com/missing/{pkg}")
+		s.RootRepo("https://github.com/nirenjan/root")
+		s.QueryRemote(false)
+		s.Repo().SetProvider("github")
+		s.Repo().SetType("git")
+		s.Repo().SetRoot("https://git.example.com/")
+		s.AddPackage(Package{Name: fmt.Sprintf("pkg%v", i)})
+		s.RemovePackage(fmt.Sprintf("pkg%v", i-1))
+		s.Index(i%2 == 0)
+		s.LandingPages(i%3 == 0, time.Second)
+		s.PreserveQuery(i%2 == 1)
+		s.RedirectCodes(http.StatusFound, http.StatusMovedPermanently, http.StatusFound)
+		s.CacheLifetimes(time.Minute, time.Minute, time.Second)
+		s.TrustedProxies([]string{"10.0.0.0/8"})
+		s.Template(`<meta name="go-import" content="{{ .GoImport }}">`)
+		s.Reload(s)
+	}
+
+	close(done)
+	wg.Wait()
+}
+
+// TestConcurrentSettings changes the settings which are only used when the
+// server is started, while requests and the admin API read them. It is meant
+// to be run with the race detector.
+func TestConcurrentSettings(t *testing.T) {
+	s, _ := NewServer("nirenjan.org", "https://github.com/nirenjan/go-", "")
+	s.QueryRemote(false)
+	s.AccessLog(nil)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.getHandler("/", s.handleGeneric))
+	admin := s.AdminHandler("")
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				rr := httptest.NewRecorder()
+				mux.ServeHTTP(rr, httptest.NewRequest("GET", "/semver?go-get=1", nil))
+				if rr.Code != http.StatusOK {
+					t.Errorf("Unexpected status %v", rr.Code)
+				}
+
+				rr = httptest.NewRecorder()
+				admin.ServeHTTP(rr, httptest.NewRequest("GET", "/config", nil))
+				if rr.Code != http.StatusOK {
+					t.Errorf("Unexpected status %v for the config", rr.Code)
+				}
+
+				rr = httptest.NewRecorder()
+				body := strings.NewReader(`{"name": "semver"}`)
+				admin.ServeHTTP(rr, httptest.NewRequest("PUT", "/packages/semver", body))
+				if rr.Code >= 300 {
+					t.Errorf("Unexpected status %v for the package", rr.Code)
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < 50; i++ {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		s.Timeouts(time.Second, time.Second, time.Second, time.Duration(i)*time.Second)
+		s.MaxHeaderBytes(1 << 12)
+		s.MaxConns(i)
+		s.ServeMetrics("/metrics", l)
+		s.HealthChecks(fmt.Sprintf("/_health%v", i))
+		s.API(fmt.Sprintf("/_api%v", i))
+		s.Listen(l)
+		s.ServeAdmin(l, "")
+		s.AdminStore(func([]Package) error { return nil })
+	}
+
+	close(done)
+	wg.Wait()
+	s.Listen(nil)
+}
@@ -16,7 +16,7 @@ func main() {
 
 	// Handle os.Interrupt
 	go func() {
-		ch := make(chan os.Signal)
+		ch := make(chan os.Signal, 1)
 
 		signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM)
 
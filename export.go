@@ -0,0 +1,121 @@
+// Copyright 2019 Nirenjan Krishnan. All rights reserved.
+
+package vanity
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"path"
+	"sort"
+	"strings"
+)
+
+// redirectTemplate is the template for a static page which redirects the
+// browser to another location
+var redirectTemplate = template.Must(template.New("redirect").Parse(`<!DOCTYPE html>
+<html>
+<head>
+	<meta charset="UTF-8">
+	<meta http-equiv="refresh" content="0;url={{ . }}">
+</head>
+<body>
+<p>Redirecting to <a href="{{ . }}">{{ . }}</a></p>
+</body>
+</html>
+`))
+
+// StaticPage is a single page of a static copy of the server
+type StaticPage struct {
+	// Path is the location of the page, relative to the root of the site,
+	// e.g., `quote/v3/index.html`
+	Path string
+
+	// Content is the HTML content of the page
+	Content []byte
+}
+
+// Export renders the pages for a static copy of the server, which can be
+// hosted where the server can't run, e.g., an object store. Every request
+// path is exported as path/index.html, so that the page is found for the
+// path with or without a trailing slash.
+//
+// The root page and the pages of the known packages are always exported.
+// The paths may add subpackages, and are either import paths or relative to
+// the base, as for Resolve. Since a static site can't tell browsers and the
+// go tool apart, the pages are the go-get responses, which redirect browsers
+// with a refresh meta tag. The landing pages are used instead, if they are
+// enabled. The root page is the package index, if it is enabled and there is
+// no root repository.
+//
+// The remote is not queried, so the pages are exported whether or not the
+// repositories exist.
+func (s *Server) Export(paths []string) ([]StaticPage, error) {
+	c := s.config()
+
+	modules := map[string]bool{"/": true}
+	for _, p := range c.packages {
+		modules["/"+p.Name] = true
+	}
+
+	for _, p := range paths {
+		module, err := c.modulePathFor(p)
+		if err != nil {
+			return nil, err
+		}
+		if strings.Contains(module, "@") {
+			return nil, fmt.Errorf("Import path %v has a version, which can't be exported", p)
+		}
+		modules[module] = true
+	}
+
+	pages := make([]StaticPage, 0, len(modules))
+	for module := range modules {
+		var buf bytes.Buffer
+		if err := c.exportPage(&buf, module); err != nil {
+			return nil, fmt.Errorf("Failed to render %v: %v", module, err)
+		}
+
+		pages = append(pages, StaticPage{
+			Path:    strings.TrimPrefix(path.Join(module, "index.html"), "/"),
+			Content: buf.Bytes(),
+		})
+	}
+
+	sort.Slice(pages, func(i, j int) bool {
+		return pages[i].Path < pages[j].Path
+	})
+	return pages, nil
+}
+
+// exportPage renders the static page for the request path
+func (c *config) exportPage(buf *bytes.Buffer, module string) error {
+	if module != "/" {
+		if c.landing {
+			return landingTemplate.Execute(buf, c.landingData(module))
+		}
+		return c.template.Execute(buf, c.templateData(module))
+	}
+
+	// The root page redirects browsers to the root redirect, since the
+	// package redirect may not apply to it
+	target := c.redirectTarget(redirectRoot, module, "")
+	redirect := c.allowedRedirect(target)
+	switch {
+	case c.rootRepo != "":
+		data := c.templateData(module)
+		data.RedirectURL = redirect
+		return c.template.Execute(buf, data)
+
+	case c.index:
+		return indexTemplate.Execute(buf, struct {
+			Base     string
+			Packages []IndexEntry
+		}{c.base, c.indexEntries(nil)})
+	}
+
+	if redirect == "" {
+		return fmt.Errorf("Root redirect %v is not allowed", target)
+	}
+	return redirectTemplate.Execute(buf, redirect)
+}
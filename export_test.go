@@ -0,0 +1,104 @@
+// Copyright 2019 Nirenjan Krishnan. All rights reserved.
+
+package vanity
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// exportedPages returns the exported pages indexed by path
+func exportedPages(t *testing.T, s *Server, paths ...string) map[string]string {
+	t.Helper()
+	pages, err := s.Export(paths)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := make(map[string]string)
+	for _, p := range pages {
+		out[p.Path] = string(p.Content)
+	}
+	return out
+}
+
+func TestExport(t *testing.T) {
+	s, _ := NewServer("nirenjan.org", "https://github.com/nirenjan/", "https://pkg.go.dev/{importpath}")
+	s.Repo().SetProvider("github")
+	s.RootRedirect("https://nirenjan.org/about")
+	s.QueryRemote(false)
+	s.AddPackage(Package{Name: "semver"})
+	s.AddPackage(Package{Name: "quote", Repo: "https://git.example.com/quote"})
+
+	pages, err := s.Export([]string{"nirenjan.org/quote/v3", "semver/core", "/semver"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := []string{"index.html", "quote/index.html", "quote/v3/index.html", "semver/core/index.html", "semver/index.html"}
+	if len(pages) != len(exp) {
+		t.Fatalf("Mismatch in number of pages, expected %v, got %v", len(exp), len(pages))
+	}
+
+	// The package pages are the same as the go-get responses
+	for i, p := range pages {
+		if p.Path != exp[i] {
+			t.Errorf("Mismatch in page path, expected %v, got %v", exp[i], p.Path)
+		}
+		if p.Path == "index.html" {
+			continue
+		}
+
+		path := "/" + strings.TrimSuffix(p.Path, "/index.html")
+		rr := httptest.NewRecorder()
+		s.handleGeneric(rr, httptest.NewRequest("GET", path+"?go-get=1", nil))
+		if rr.Body.String() != string(p.Content) {
+			t.Errorf("Mismatch in content for %v, expected\n%s\ngot\n%s", p.Path, rr.Body.String(), p.Content)
+		}
+	}
+
+	if !strings.Contains(string(pages[2].Content), `content="nirenjan.org/quote git https://git.example.com/quote"`) ||
+		!strings.Contains(string(pages[2].Content), `url=https://pkg.go.dev/nirenjan.org/quote/v3"`) {
+		t.Errorf("Unexpected content for subpackage:\n%s", pages[2].Content)
+	}
+
+	checks := []struct {
+		rootRepo string
+		index    bool
+		landing  bool
+		path     string
+		content  string
+	}{
+		{"", false, false, "index.html", `content="0;url=https://nirenjan.org/about"`},
+		{"", true, false, "index.html", "<h1>nirenjan.org</h1>"},
+		{"https://github.com/nirenjan/root", true, false, "index.html",
+			`content="nirenjan.org git https://github.com/nirenjan/root"`},
+		{"https://github.com/nirenjan/root", false, false, "index.html", `content="0;url=https://nirenjan.org/about"`},
+		{"", false, true, "semver/index.html", "<pre>go get nirenjan.org/semver</pre>"},
+		{"", false, true, "semver/index.html", `content="nirenjan.org/semver git https://github.com/nirenjan/semver"`},
+	}
+
+	for _, c := range checks {
+		s.RootRepo(c.rootRepo)
+		s.Index(c.index)
+		s.LandingPages(c.landing, time.Second)
+
+		out := exportedPages(t, s)
+		if !strings.Contains(out[c.path], c.content) {
+			t.Errorf("Missing %v in %v with root repo %#v, index %v, landing %v:\n%v",
+				c.content, c.path, c.rootRepo, c.index, c.landing, out[c.path])
+		}
+	}
+}
+
+func TestExportErrors(t *testing.T) {
+	s, _ := NewServer("nirenjan.org", "https://github.com/nirenjan/", "")
+
+	for _, p := range []string{"semver@v1.0.0", "github.com/nirenjan/semver", "Semver", "nirenjan.org"} {
+		if _, err := s.Export([]string{p}); err == nil {
+			t.Errorf("Expected error for %v, got success", p)
+		}
+	}
+}
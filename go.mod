@@ -1,3 +1,5 @@
 module nirenjan.org/vanity
 
-go 1.11
+go 1.18
+
+require github.com/BurntSushi/toml v1.6.0
@@ -0,0 +1,101 @@
+// Copyright 2019 Nirenjan Krishnan. All rights reserved.
+
+package vanity
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// lifetimes is the set of cache lifetimes for each kind of response. A
+// positive value allows caching for that duration, zero requires the cache
+// to revalidate the response, and a negative value omits the Cache-Control
+// header altogether.
+type lifetimes struct {
+	meta     time.Duration
+	redirect time.Duration
+	notFound time.Duration
+}
+
+// setCacheControl sets the Cache-Control header for the given lifetime
+func setCacheControl(w http.ResponseWriter, d time.Duration) {
+	switch {
+	case d > 0:
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int64(d/time.Second)))
+
+	case d == 0:
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+}
+
+// CacheLifetimes controls the Cache-Control header sent with the responses.
+// meta applies to the pages with the go-import meta tags, redirect applies
+// to the browser redirects, and notFound applies to the 404 responses when
+// the upstream does not exist. A zero value requires caches to revalidate
+// the response, and a negative value disables the header.
+func (s *Server) CacheLifetimes(meta, redirect, notFound time.Duration) {
+	s.update(func(c *config) error {
+		c.lifetimes = lifetimes{meta, redirect, notFound}
+		return nil
+	})
+}
+
+// computeETag returns a strong entity tag for the response body
+func computeETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// etagMatch checks if the etag matches any in the If-None-Match header,
+// using the weak comparison function as required by RFC 7232.
+func etagMatch(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// notModified checks the conditional request headers, and returns true if
+// the client has a fresh copy of the response. The entity tag is compared
+// whenever the request has an If-None-Match header, and If-Modified-Since
+// is only checked otherwise.
+//
+// If-Modified-Since only has a resolution of a second, so if the replaced
+// configuration was modified in the same second, a client with that time
+// may have a page of the replaced configuration. In that case, the client
+// must have a copy from a later second.
+func notModified(r *http.Request, etag string, modified, replaced time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return etagMatch(inm, etag)
+	}
+
+	ims := r.Header.Get("If-Modified-Since")
+	if ims == "" || modified.IsZero() {
+		return false
+	}
+
+	t, err := http.ParseTime(ims)
+	if err != nil {
+		return false
+	}
+
+	last := modified.Truncate(time.Second)
+	if replaced.Truncate(time.Second).Equal(last) {
+		return t.After(last)
+	}
+	return !last.After(t)
+}
+
+// sendNotFound sends the 404 response with the not found cache lifetime
+func (s *Server) sendNotFound(w http.ResponseWriter, r *http.Request) {
+	setCacheControl(w, s.requestConfig(r).lifetimes.notFound)
+	http.NotFound(w, r)
+}
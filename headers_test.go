@@ -0,0 +1,182 @@
+// Copyright 2019 Nirenjan Krishnan. All rights reserved.
+
+package vanity
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSetCacheControl(t *testing.T) {
+	checks := []struct {
+		d   time.Duration
+		out string
+	}{
+		{time.Hour, "public, max-age=3600"},
+		{time.Second * 90, "public, max-age=90"},
+		{0, "no-cache"},
+		{-1, ""},
+	}
+
+	for _, c := range checks {
+		rr := httptest.NewRecorder()
+		setCacheControl(rr, c.d)
+
+		if cc := rr.Header().Get("Cache-Control"); cc != c.out {
+			t.Errorf("Mismatch in Cache-Control for %v, expected %#v, got %#v", c.d, c.out, cc)
+		}
+	}
+}
+
+func TestNotModified(t *testing.T) {
+	modified := time.Date(2019, time.October, 10, 13, 55, 36, 5e8, time.UTC)
+	etag := `"abcd"`
+
+	checks := []struct {
+		header string
+		value  string
+		prev   time.Duration
+		out    bool
+	}{
+		{"", "", 0, false},
+		{"If-None-Match", `"abcd"`, 0, true},
+		{"If-None-Match", `W/"abcd"`, 0, true},
+		{"If-None-Match", `"1234", "abcd"`, 0, true},
+		{"If-None-Match", `*`, 0, true},
+		{"If-None-Match", `"1234"`, 0, false},
+		{"If-Modified-Since", "Thu, 10 Oct 2019 13:55:36 GMT", 0, true},
+		{"If-Modified-Since", "Fri, 11 Oct 2019 00:00:00 GMT", 0, true},
+		{"If-Modified-Since", "Wed, 09 Oct 2019 00:00:00 GMT", 0, false},
+		{"If-Modified-Since", "invalid", 0, false},
+
+		// The replaced configuration has the same Last-Modified time, so
+		// only a copy from a later second is fresh
+		{"If-Modified-Since", "Thu, 10 Oct 2019 13:55:36 GMT", time.Second / 4, false},
+		{"If-Modified-Since", "Thu, 10 Oct 2019 13:55:37 GMT", time.Second / 4, true},
+		{"If-Modified-Since", "Thu, 10 Oct 2019 13:55:36 GMT", time.Second, true},
+
+		// The entity tag takes precedence
+		{"If-None-Match", `"abcd"`, time.Second / 4, true},
+	}
+
+	for _, c := range checks {
+		req := httptest.NewRequest("GET", "/", nil)
+		if c.header != "" {
+			req.Header.Set(c.header, c.value)
+		}
+
+		var replaced time.Time
+		if c.prev != 0 {
+			replaced = modified.Add(-c.prev)
+		}
+		if res := notModified(req, etag, modified, replaced); res != c.out {
+			t.Errorf("Mismatch in notModified(%v: %v, %v), expected %v, got %v", c.header, c.value, c.prev, c.out, res)
+		}
+	}
+}
+
+func TestGenericCaching(t *testing.T) {
+	mock := mockServer(t)
+	defer mock.Close()
+
+	s, _ := NewServer("base", mockAddr(mock), "")
+	s.client = mock.Client()
+	s.CacheLifetimes(time.Hour, time.Minute, time.Second*30)
+
+	serve := func(path, inm string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		if inm != "" {
+			req.Header.Set("If-None-Match", inm)
+		}
+		rr := httptest.NewRecorder()
+		s.handleGeneric(rr, req)
+		return rr
+	}
+
+	rr := serve("/valid?go-get=1", "")
+	etag := rr.Header().Get("ETag")
+	if rr.Code != http.StatusOK || etag == "" || rr.Header().Get("Last-Modified") == "" {
+		t.Fatalf("Unexpected meta response %v %v", rr.Code, rr.Header())
+	}
+	if cc := rr.Header().Get("Cache-Control"); cc != "public, max-age=3600" {
+		t.Errorf("Unexpected meta Cache-Control %#v", cc)
+	}
+
+	// The ETag is stable across requests
+	if rr2 := serve("/valid?go-get=1", ""); rr2.Header().Get("ETag") != etag {
+		t.Errorf("ETag changed between requests, %v != %v", rr2.Header().Get("ETag"), etag)
+	}
+
+	rr = serve("/valid?go-get=1", etag)
+	if rr.Code != http.StatusNotModified || rr.Body.Len() != 0 {
+		t.Errorf("Expected 304 with no body, got %v %#v", rr.Code, rr.Body.String())
+	}
+
+	if rr = serve("/valid?go-get=1", `"stale"`); rr.Code != http.StatusOK {
+		t.Errorf("Expected 200 for a stale tag, got %v", rr.Code)
+	}
+
+	if rr = serve("/valid", ""); rr.Header().Get("Cache-Control") != "public, max-age=60" {
+		t.Errorf("Unexpected redirect Cache-Control %#v", rr.Header().Get("Cache-Control"))
+	}
+
+	if rr = serve("/invalid", ""); rr.Header().Get("Cache-Control") != "public, max-age=30" {
+		t.Errorf("Unexpected 404 Cache-Control %#v", rr.Header().Get("Cache-Control"))
+	}
+}
+
+func TestModifiedAfterChange(t *testing.T) {
+	s, _ := NewServer("base", "https://github.com/nirenjan/", "")
+	s.QueryRemote(false)
+	s.AddPackage(Package{Name: "semver"})
+
+	serve := func(ims string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/semver?go-get=1", nil)
+		if ims != "" {
+			req.Header.Set("If-Modified-Since", ims)
+		}
+		rr := httptest.NewRecorder()
+		s.handleGeneric(rr, req)
+		return rr
+	}
+
+	// lastModified makes the current configuration the only one with its
+	// Last-Modified time, and returns the Last-Modified header of the
+	// response, which is then fresh
+	lastModified := func() string {
+		c := s.config().clone()
+		c.replaced = time.Time{}
+		s.cfg.Store(c)
+		return serve("").Header().Get("Last-Modified")
+	}
+
+	checks := []struct {
+		name   string
+		change func()
+	}{
+		{"AddPackage", func() { s.AddPackage(Package{Name: "semver", Repo: "https://git.example.com/semver"}) }},
+		{"RemovePackage", func() { s.RemovePackage("semver") }},
+		{"RootRedirect", func() { s.RootRedirect("https://example.com") }},
+		{"SetType", func() { s.Repo().SetType("mercurial") }},
+		{"Reload", func() {
+			next, _ := NewServer("base", "https://git.example.com/", "")
+			s.Reload(next)
+		}},
+	}
+
+	// The changes are usually made in the same second as the previous
+	// change, which Last-Modified can't tell apart
+	for _, c := range checks {
+		modified := lastModified()
+		if rr := serve(modified); rr.Code != http.StatusNotModified {
+			t.Errorf("Expected 304 before %v, got %v", c.name, rr.Code)
+		}
+
+		c.change()
+		if rr := serve(modified); rr.Code == http.StatusNotModified {
+			t.Errorf("Mismatch in response after %v, expected a fresh page, got %v", c.name, rr.Code)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+// Copyright 2019 Nirenjan Krishnan. All rights reserved.
+
+package vanity
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// healthWindow is the duration for which a successful upstream check keeps
+// the server ready, even if later checks fail.
+const healthWindow = time.Minute
+
+// health tracks the state reported by the readiness endpoint
+type health struct {
+	// serving is set to 1 while the listener is accepting requests. It
+	// is accessed atomically.
+	serving int32
+
+	mu sync.Mutex
+
+	// lastSuccess and lastError are the times of the most recent upstream
+	// checks which got a response, and which failed to get one.
+	lastSuccess time.Time
+	lastError   time.Time
+
+	// lastCode is the status code of the most recent failed check
+	lastCode int
+}
+
+func (h *health) setServing(serving bool) {
+	var v int32
+	if serving {
+		v = 1
+	}
+	atomic.StoreInt32(&h.serving, v)
+}
+
+func (h *health) isServing() bool {
+	return atomic.LoadInt32(&h.serving) == 1
+}
+
+// observeUpstream records the result of an upstream check. Any response
+// other than a server error means that the upstream is reachable.
+func (h *health) observeUpstream(code int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if code >= 500 {
+		h.lastError = time.Now()
+		h.lastCode = code
+	} else {
+		h.lastSuccess = time.Now()
+	}
+}
+
+// upstreamHealthy checks if the upstream is considered to be reachable. It
+// is unhealthy only if the most recent check failed, and there have been
+// no successful checks within the health window.
+func (h *health) upstreamHealthy() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.lastError.IsZero() || h.lastSuccess.After(h.lastError) {
+		return true
+	}
+
+	return time.Since(h.lastSuccess) < healthWindow
+}
+
+// timeString formats the time for the JSON report, or returns an empty
+// string if the time is not set
+func timeString(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// HealthChecks enables the liveness and readiness endpoints at prefix/live
+// and prefix/ready. The liveness endpoint always responds with 200 while
+// the server is running. The readiness endpoint responds with 200 when the
+// listener is up and upstream checks have recently succeeded, and 503
+// otherwise. An empty prefix disables both endpoints. The endpoints are only
+// added when the server is started.
+func (s *Server) HealthChecks(prefix string) {
+	if prefix != "" && prefix[0] != '/' {
+		prefix = "/" + prefix
+	}
+
+	for len(prefix) > 1 && prefix[len(prefix)-1] == '/' {
+		prefix = prefix[:len(prefix)-1]
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.healthPath = prefix
+}
+
+// handleLive responds to the liveness probe
+func (s *Server) handleLive(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write([]byte("ok\n"))
+}
+
+// handleReady responds to the readiness probe with a JSON description of
+// the listener, upstream and cache state.
+func (s *Server) handleReady(w http.ResponseWriter, r *http.Request) {
+	entries, ttl := s.cache.stats()
+	queryRemote := s.requestConfig(r).queryRemote
+	healthy := !queryRemote || s.health.upstreamHealthy()
+	serving := s.health.isServing()
+
+	s.health.mu.Lock()
+	report := struct {
+		Status   string `json:"status"`
+		Listener bool   `json:"listener"`
+		Upstream struct {
+			QueryRemote bool   `json:"query_remote"`
+			Healthy     bool   `json:"healthy"`
+			LastSuccess string `json:"last_success,omitempty"`
+			LastError   string `json:"last_error,omitempty"`
+			LastCode    int    `json:"last_error_code,omitempty"`
+		} `json:"upstream"`
+		Cache struct {
+			TTL     string `json:"ttl"`
+			Entries int    `json:"entries"`
+		} `json:"cache"`
+	}{
+		Status:   "ok",
+		Listener: serving,
+	}
+	report.Upstream.QueryRemote = queryRemote
+	report.Upstream.Healthy = healthy
+	report.Upstream.LastSuccess = timeString(s.health.lastSuccess)
+	report.Upstream.LastError = timeString(s.health.lastError)
+	report.Upstream.LastCode = s.health.lastCode
+	s.health.mu.Unlock()
+
+	report.Cache.TTL = ttl.String()
+	report.Cache.Entries = entries
+
+	code := http.StatusOK
+	if !serving || !healthy {
+		report.Status = "unavailable"
+		code = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,118 @@
+// Copyright 2019 Nirenjan Krishnan. All rights reserved.
+
+package vanity
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHealthChecksPrefix(t *testing.T) {
+	checks := []struct {
+		in  string
+		out string
+	}{
+		{"", ""},
+		{"/", "/"},
+		{"_health", "/_health"},
+		{"/_health/", "/_health"},
+	}
+
+	s, _ := NewServer("base", "root", "")
+	for _, c := range checks {
+		s.HealthChecks(c.in)
+		if s.healthPath != c.out {
+			t.Errorf("Mismatch in HealthChecks(%#v), expected %#v, got %#v", c.in, c.out, s.healthPath)
+		}
+	}
+}
+
+func TestUpstreamHealthy(t *testing.T) {
+	var h health
+
+	if !h.upstreamHealthy() {
+		t.Errorf("Expected healthy upstream with no checks")
+	}
+
+	h.observeUpstream(http.StatusServiceUnavailable)
+	if h.upstreamHealthy() {
+		t.Errorf("Expected unhealthy upstream after a failed check")
+	}
+
+	h.observeUpstream(http.StatusNotFound)
+	if !h.upstreamHealthy() {
+		t.Errorf("Expected healthy upstream after a successful check")
+	}
+
+	// A recent success keeps the upstream healthy
+	h.observeUpstream(http.StatusServiceUnavailable)
+	if !h.upstreamHealthy() {
+		t.Errorf("Expected healthy upstream within the health window")
+	}
+
+	h.lastSuccess = time.Now().Add(-2 * healthWindow)
+	if h.upstreamHealthy() {
+		t.Errorf("Expected unhealthy upstream outside the health window")
+	}
+}
+
+func TestHandleReady(t *testing.T) {
+	mock := mockServer(t)
+	defer mock.Close()
+
+	s, _ := NewServer("base", mockAddr(mock), "")
+	s.client = mock.Client()
+	s.client.Timeout = time.Second / 10
+	s.CacheTTL(time.Minute)
+
+	ready := func() (int, map[string]interface{}) {
+		rr := httptest.NewRecorder()
+		s.handleReady(rr, httptest.NewRequest("GET", "/_health/ready", nil))
+
+		var report map[string]interface{}
+		if err := json.Unmarshal(rr.Body.Bytes(), &report); err != nil {
+			t.Fatalf("Invalid JSON %#v: %v", rr.Body.String(), err)
+		}
+		return rr.Code, report
+	}
+
+	// Not ready until the listener is up
+	if code, report := ready(); code != http.StatusServiceUnavailable || report["listener"] != false {
+		t.Errorf("Unexpected readiness before serving: %v %v", code, report)
+	}
+
+	s.health.setServing(true)
+	if code, report := ready(); code != http.StatusOK || report["status"] != "ok" {
+		t.Errorf("Unexpected readiness while serving: %v %v", code, report)
+	}
+
+	// A failing upstream makes the server unready
+	s.upstreamExists(httptest.NewRequest("GET", "/timeout", nil), "/timeout")
+	code, report := ready()
+	upstream := report["upstream"].(map[string]interface{})
+	if code != http.StatusServiceUnavailable || upstream["healthy"] != false ||
+		upstream["last_error_code"] != float64(http.StatusServiceUnavailable) {
+		t.Errorf("Unexpected readiness with failed upstream: %v %v", code, report)
+	}
+
+	// A successful upstream check is cached, and makes the server ready
+	s.upstreamExists(httptest.NewRequest("GET", "/valid", nil), "/valid")
+	code, report = ready()
+	cache := report["cache"].(map[string]interface{})
+	if code != http.StatusOK || cache["entries"] != 1.0 || cache["ttl"] != "1m0s" {
+		t.Errorf("Unexpected readiness with cached upstream: %v %v", code, report)
+	}
+}
+
+func TestHandleLive(t *testing.T) {
+	s, _ := NewServer("base", "root", "")
+
+	rr := httptest.NewRecorder()
+	s.handleLive(rr, httptest.NewRequest("GET", "/_health/live", nil))
+	if rr.Code != http.StatusOK || rr.Body.String() != "ok\n" {
+		t.Errorf("Unexpected liveness response %v %#v", rr.Code, rr.Body.String())
+	}
+}
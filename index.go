@@ -0,0 +1,175 @@
+// Copyright 2019 Nirenjan Krishnan. All rights reserved.
+
+package vanity
+
+import (
+	"bytes"
+	"encoding/json"
+	"html/template"
+	"log"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// IndexEntry is a single package listed in the index. Discovered is set for
+// packages which are not configured, but were recently found upstream.
+type IndexEntry struct {
+	ImportPath  string `json:"import_path"`
+	Repo        string `json:"repository"`
+	Description string `json:"description,omitempty"`
+	Docs        string `json:"docs"`
+	Discovered  bool   `json:"discovered,omitempty"`
+}
+
+// indexTemplate is the template for the HTML index page
+var indexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html>
+<head>
+	<meta charset="UTF-8">
+	<title>{{ .Base }}</title>
+</head>
+<body>
+<h1>{{ .Base }}</h1>
+<table>
+<tr><th>Package</th><th>Description</th><th>Repository</th><th>Documentation</th></tr>
+{{- range .Packages }}
+<tr><td><code>{{ .ImportPath }}</code></td><td>{{ if .Discovered }}<em>Found upstream</em>{{ else }}{{ .Description }}{{ end }}</td><td><a href="{{ .Repo }}">{{ .Repo }}</a></td><td>{{ if .Docs }}<a href="{{ .Docs }}">{{ .Docs }}</a>{{ end }}</td></tr>
+{{- end }}
+</table>
+</body>
+</html>
+`))
+
+// Index controls whether browser requests to the root `/` endpoint are
+// served a list of the known packages, instead of being redirected to the
+// root redirect. The list is returned as JSON if the request prefers
+// `application/json`. Besides the configured packages, it includes the
+// packages which were found upstream and are still in the cache, marked as
+// discovered. The exported and proxy index pages only list the configured
+// packages, since they are generated ahead of the requests.
+func (s *Server) Index(enable bool) {
+	s.update(func(c *config) error {
+		c.index = enable
+		return nil
+	})
+}
+
+// indexEntries returns the index entries for all the configured packages,
+// and the discovered repository bases which are not configured, sorted by
+// import path
+func (c *config) indexEntries(discovered []string) []IndexEntry {
+	packages := c.sortedPackages()
+	entries := make([]IndexEntry, 0, len(packages)+len(discovered))
+
+	for _, p := range packages {
+		entries = append(entries, IndexEntry{
+			ImportPath:  c.base + "/" + p.Name,
+			Repo:        c.repoURL(p.Name),
+			Description: p.Description,
+			Docs:        c.allowedRedirect(c.docsURL(p.Name)),
+		})
+	}
+
+	for _, name := range discovered {
+		if _, ok := c.packages[name]; ok || name == "" {
+			continue
+		}
+		entries = append(entries, IndexEntry{
+			ImportPath: c.base + "/" + name,
+			Repo:       c.repoURL(name),
+			Docs:       c.allowedRedirect(c.docsURL(name)),
+			Discovered: true,
+		})
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].ImportPath < entries[j].ImportPath
+	})
+	return entries
+}
+
+// acceptQuality returns the quality value of the media type in the Accept
+// header, taking wildcards into account.
+func acceptQuality(accept, mediaType string) float64 {
+	best := -1
+	quality := 0.0
+	major := strings.SplitN(mediaType, "/", 2)[0]
+
+	for _, part := range strings.Split(accept, ",") {
+		mt, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+
+		// Prefer the most specific match
+		specificity := -1
+		switch mt {
+		case mediaType:
+			specificity = 2
+		case major + "/*":
+			specificity = 1
+		case "*/*":
+			specificity = 0
+		}
+		if specificity <= best {
+			continue
+		}
+
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		best, quality = specificity, q
+	}
+
+	return quality
+}
+
+// wantsJSON checks if the client prefers JSON over HTML
+func wantsJSON(r *http.Request) bool {
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return false
+	}
+
+	return acceptQuality(accept, "application/json") > acceptQuality(accept, "text/html")
+}
+
+// handleIndex serves the package index as HTML or JSON
+func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
+	c := s.requestConfig(r)
+	entries := c.indexEntries(s.cache.found())
+
+	var buf bytes.Buffer
+	var err error
+	contentType := "text/html; charset=utf-8"
+	if wantsJSON(r) {
+		contentType = "application/json"
+		err = json.NewEncoder(&buf).Encode(struct {
+			Base     string       `json:"base"`
+			Packages []IndexEntry `json:"packages"`
+		}{c.base, entries})
+	} else {
+		err = indexTemplate.Execute(&buf, struct {
+			Base     string
+			Packages []IndexEntry
+		}{c.base, entries})
+	}
+
+	if err != nil {
+		log.Print(err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	h := w.Header()
+	h.Set("Vary", "Accept")
+	h.Set("Content-Type", contentType)
+	setCacheControl(w, c.lifetimes.meta)
+	w.Write(buf.Bytes())
+}
@@ -0,0 +1,110 @@
+// Copyright 2019 Nirenjan Krishnan. All rights reserved.
+
+package vanity
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAcceptQuality(t *testing.T) {
+	checks := []struct {
+		accept string
+		json   bool
+	}{
+		{"", false},
+		{"*/*", false},
+		{"application/json", true},
+		{"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", false},
+		{"application/json, text/html;q=0.5", true},
+		{"text/html, application/json;q=0.5", false},
+		{"application/*", true},
+		{"invalid;;", false},
+	}
+
+	for _, c := range checks {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept", c.accept)
+
+		if res := wantsJSON(req); res != c.json {
+			t.Errorf("Mismatch in wantsJSON(%#v), expected %v, got %v", c.accept, c.json, res)
+		}
+	}
+}
+
+func TestIndex(t *testing.T) {
+	s, _ := NewServer("nirenjan.org", "https://github.com/nirenjan/go-", "https://pkg.go.dev/nirenjan.org")
+	s.QueryRemote(false)
+	s.AddPackage(Package{Name: "semver", Description: "Semantic versioning <library>"})
+	s.AddPackage(Package{Name: "vanity", Repo: "https://git.example.com/vanity", Docs: "https://docs.example.com/vanity"})
+
+	// Packages found upstream are listed, unless they are configured or
+	// were not found
+	s.CacheTTL(time.Minute)
+	s.cache.put("quote", cacheEntry{exists: true, code: http.StatusOK, checked: time.Now()})
+	s.cache.put("absent", cacheEntry{exists: false, code: http.StatusNotFound, checked: time.Now()})
+	s.cache.put("semver", cacheEntry{exists: true, code: http.StatusOK, checked: time.Now()})
+
+	serve := func(accept, query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/"+query, nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		rr := httptest.NewRecorder()
+		s.handleGeneric(rr, req)
+		return rr
+	}
+
+	// Disabled by default
+	if rr := serve("", ""); rr.Code != http.StatusFound {
+		t.Errorf("Expected redirect with index disabled, got %v", rr.Code)
+	}
+
+	s.Index(true)
+	rr := serve("text/html", "")
+	body := rr.Body.String()
+	if rr.Code != http.StatusOK || !strings.Contains(body, "<code>nirenjan.org/semver</code>") ||
+		!strings.Contains(body, "Semantic versioning &lt;library&gt;") ||
+		!strings.Contains(body, `<a href="https://pkg.go.dev/nirenjan.org/semver">`) ||
+		!strings.Contains(body, `<a href="https://git.example.com/vanity">`) ||
+		!strings.Contains(body, "<code>nirenjan.org/quote</code></td><td><em>Found upstream</em>") ||
+		strings.Contains(body, "absent") {
+		t.Errorf("Unexpected HTML index %v:\n%s", rr.Code, body)
+	}
+
+	rr = serve("application/json", "")
+	if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Unexpected content type %#v", ct)
+	}
+
+	var out struct {
+		Base     string       `json:"base"`
+		Packages []IndexEntry `json:"packages"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &out); err != nil {
+		t.Fatalf("Invalid JSON %#v: %v", rr.Body.String(), err)
+	}
+
+	expected := []IndexEntry{
+		{"nirenjan.org/quote", "https://github.com/nirenjan/go-quote", "", "https://pkg.go.dev/nirenjan.org/quote", true},
+		{"nirenjan.org/semver", "https://github.com/nirenjan/go-semver", "Semantic versioning <library>", "https://pkg.go.dev/nirenjan.org/semver", false},
+		{"nirenjan.org/vanity", "https://git.example.com/vanity", "", "https://docs.example.com/vanity", false},
+	}
+	if out.Base != "nirenjan.org" || len(out.Packages) != len(expected) {
+		t.Fatalf("Unexpected JSON index %#v", out)
+	}
+	for i, e := range expected {
+		if out.Packages[i] != e {
+			t.Errorf("Mismatch in index entry %d, expected %#v, got %#v", i, e, out.Packages[i])
+		}
+	}
+
+	// go-get requests to the root are still redirected
+	if rr := serve("", "?go-get=1"); rr.Code != http.StatusFound {
+		t.Errorf("Expected redirect for go-get, got %v", rr.Code)
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright 2019 Nirenjan Krishnan. All rights reserved.
+
+package vanity
+
+import (
+	"html/template"
+	"net/http"
+	"time"
+)
+
+// landingTemplate is the template for the package landing page
+var landingTemplate = template.Must(template.New("landing").Parse(`<!DOCTYPE html>
+<html>
+<head>
+	<meta charset="UTF-8">
+	<meta name="go-import" content="{{ .GoImport }}">
+{{- if .GoSource }}
+	<meta name="go-source" content="{{ .GoSource }}">
+{{- end }}
+{{- if and (gt .Delay 0) .RedirectURL }}
+	<meta http-equiv="refresh" content="{{ .Delay }};url={{ .RedirectURL }}">
+{{- end }}
+	<title>{{ .Path }}</title>
+</head>
+<body>
+<h1>{{ .Path }}</h1>
+{{- if .Description }}
+<p>{{ .Description }}</p>
+{{- end }}
+<pre>go get {{ .Path }}{{ if .Install }}@{{ .Install }}{{ end }}</pre>
+<ul>
+<li>Repository: <a href="{{ .RepoURL }}">{{ .RepoURL }}</a></li>
+{{- if ne .SourceURL .RepoURL }}
+<li>Source: <a href="{{ .SourceURL }}">{{ .SourceURL }}</a></li>
+{{- end }}
+{{- if .Docs }}
+<li>Documentation: <a href="{{ .Docs }}">{{ .Docs }}</a></li>
+{{- end }}
+{{- if .Version }}
+<li>Latest version: {{ .Version }}</li>
+{{- end }}
+</ul>
+{{- if .RedirectURL }}
+<p>{{ if gt .Delay 0 }}Redirecting in {{ .Delay }} seconds to{{ else }}Continue to{{ end }} <a href="{{ .RedirectURL }}">{{ .RedirectURL }}</a></p>
+{{- end }}
+</body>
+</html>
+`))
+
+// landingData is the data passed to the landing page template
+type landingData struct {
+	*TemplateData
+
+	// Path is the full import path that was requested, without the version
+	Path string
+
+	// Install is the version in the install command, which is either the
+	// requested version or the latest known version.
+	Install string
+
+	// Delay is the number of seconds before the browser is redirected,
+	// and is 0 if the browser is not redirected automatically.
+	Delay int
+}
+
+// LandingPages controls whether browsers are served a landing page for the
+// package instead of being redirected immediately. The landing page shows
+// the import path, the install command, and links to the repository and
+// documentation. If delay is positive, the browser is redirected after the
+// delay, otherwise it only shows a link to the redirect location.
+func (s *Server) LandingPages(enable bool, delay time.Duration) {
+	s.update(func(c *config) error {
+		c.landing = enable
+		c.landingDelay = delay
+		return nil
+	})
+}
+
+// serveLanding serves the landing page for the requested path
+func (s *Server) serveLanding(w http.ResponseWriter, r *http.Request, req string) {
+	c := s.requestConfig(r)
+	c.serveHTML(w, r, landingTemplate, c.landingData(req))
+}
+
+// landingData builds the landing page data for the requested path
+func (c *config) landingData(req string) *landingData {
+	m := parseModulePath(req)
+	data := &landingData{TemplateData: c.templateData(req)}
+	data.Path = c.base + "/" + m.path()
+	data.Install = m.version
+	if data.Install == "" {
+		data.Install = data.Version
+	}
+
+	if c.landingDelay > 0 {
+		// Round up to the next second, so that a sub-second delay
+		// doesn't disable the redirect
+		data.Delay = int((c.landingDelay + time.Second - 1) / time.Second)
+	}
+
+	return data
+}
@@ -0,0 +1,71 @@
+// Copyright 2019 Nirenjan Krishnan. All rights reserved.
+
+package vanity
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLandingPage(t *testing.T) {
+	s, _ := NewServer("nirenjan.org", "https://github.com/nirenjan/go-", "https://pkg.go.dev/nirenjan.org")
+	s.QueryRemote(false)
+	s.AddPackage(Package{Name: "semver", Description: "Semantic versioning", Version: "v1.2.3"})
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		s.handleGeneric(rr, httptest.NewRequest("GET", path, nil))
+		return rr
+	}
+
+	s.LandingPages(true, 0)
+	rr := serve("/semver/core")
+	body := rr.Body.String()
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Unexpected status code %v, expected %v", rr.Code, http.StatusOK)
+	}
+
+	expected := []string{
+		`<h1>nirenjan.org/semver/core</h1>`,
+		`<p>Semantic versioning</p>`,
+		`<pre>go get nirenjan.org/semver/core@v1.2.3</pre>`,
+		`<a href="https://github.com/nirenjan/go-semver">`,
+		`<a href="https://pkg.go.dev/nirenjan.org/semver">`,
+		`<li>Latest version: v1.2.3</li>`,
+		`Continue to <a href="https://pkg.go.dev/nirenjan.org/semver/core">`,
+	}
+	for _, e := range expected {
+		if !strings.Contains(body, e) {
+			t.Errorf("Missing %#v in landing page:\n%s", e, body)
+		}
+	}
+	if strings.Contains(body, "http-equiv") {
+		t.Errorf("Unexpected refresh in landing page without delay:\n%s", body)
+	}
+
+	s.LandingPages(true, time.Second*5/2)
+	body = serve("/semver").Body.String()
+	if !strings.Contains(body, `<meta http-equiv="refresh" content="3;url=https://pkg.go.dev/nirenjan.org/semver">`) {
+		t.Errorf("Missing refresh in landing page with delay:\n%s", body)
+	}
+
+	// Unknown packages have no version
+	body = serve("/other").Body.String()
+	if !strings.Contains(body, `<pre>go get nirenjan.org/other</pre>`) {
+		t.Errorf("Unexpected install command for unknown package:\n%s", body)
+	}
+
+	// go-get requests still get the meta page
+	body = serve("/semver?go-get=1").Body.String()
+	if strings.Contains(body, "<h1>") {
+		t.Errorf("Unexpected landing page for go-get request:\n%s", body)
+	}
+
+	s.LandingPages(false, 0)
+	if rr := serve("/semver"); rr.Code != http.StatusFound {
+		t.Errorf("Expected redirect with landing pages disabled, got %v", rr.Code)
+	}
+}
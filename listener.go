@@ -0,0 +1,83 @@
+// Copyright 2019 Nirenjan Krishnan. All rights reserved.
+
+package vanity
+
+import (
+	"net"
+	"sync"
+)
+
+// limitListener returns a Listener that accepts at most n simultaneous
+// connections from the provided Listener. If n is not positive, the
+// original Listener is returned unchanged.
+func limitListener(l net.Listener, n int) net.Listener {
+	if n <= 0 {
+		return l
+	}
+
+	return &limitedListener{
+		Listener: l,
+		sem:      make(chan struct{}, n),
+		done:     make(chan struct{}),
+	}
+}
+
+type limitedListener struct {
+	net.Listener
+	sem       chan struct{}
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+// acquire waits for a free slot, and returns false if the listener was
+// closed while waiting.
+func (l *limitedListener) acquire() bool {
+	select {
+	case <-l.done:
+		return false
+	case l.sem <- struct{}{}:
+		return true
+	}
+}
+
+func (l *limitedListener) release() {
+	<-l.sem
+}
+
+// Accept blocks until a connection slot is available, and then waits for
+// the next connection on the underlying listener.
+func (l *limitedListener) Accept() (net.Conn, error) {
+	if !l.acquire() {
+		// The listener is closed, let the underlying listener
+		// return the appropriate error.
+		return l.Listener.Accept()
+	}
+
+	c, err := l.Listener.Accept()
+	if err != nil {
+		l.release()
+		return nil, err
+	}
+
+	return &limitedConn{Conn: c, release: l.release}, nil
+}
+
+// Close closes the underlying listener and unblocks any pending Accept.
+func (l *limitedListener) Close() error {
+	err := l.Listener.Close()
+	l.closeOnce.Do(func() { close(l.done) })
+	return err
+}
+
+type limitedConn struct {
+	net.Conn
+	releaseOnce sync.Once
+	release     func()
+}
+
+// Close closes the connection and frees up the slot in the listener.
+func (c *limitedConn) Close() error {
+	err := c.Conn.Close()
+	c.releaseOnce.Do(c.release)
+	return err
+}
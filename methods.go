@@ -9,17 +9,72 @@ import (
 	"time"
 )
 
+// requestInfo collects the details of the request processing which are not
+// visible in the response, so that they can be recorded in the access log.
+// It also holds the configuration used to handle the request.
+type requestInfo struct {
+	cfg    *config
+	remote string
+	host   string
+	proto  string
+	goGet  bool
+
+	// forwarded is set if the host and protocol were given by a trusted
+	// proxy
+	forwarded bool
+
+	cacheHit bool
+	upstream time.Duration
+}
+
+type contextKey int
+
+const requestInfoKey contextKey = 0
+
+// getRequestInfo returns the requestInfo attached to the request context.
+// If there is none, e.g., when the handler is called directly, it returns
+// a new requestInfo which is discarded after use.
+func getRequestInfo(r *http.Request) *requestInfo {
+	if info, ok := r.Context().Value(requestInfoKey).(*requestInfo); ok {
+		return info
+	}
+	return new(requestInfo)
+}
+
+// isGoGet checks if the request has go-get=1 in the query
+func isGoGet(r *http.Request) bool {
+	// Search all the values for a matching one
+	for _, v := range r.URL.Query()["go-get"] {
+		if v == "1" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// allowedMethods is the list of methods supported by all the endpoints
+const allowedMethods = "GET, HEAD, OPTIONS"
+
 // methodNotAllowed returns a not allowed response
 func methodNotAllowed(w http.ResponseWriter, r *http.Request) {
 	code := http.StatusMethodNotAllowed
 	message := http.StatusText(code)
 
+	w.Header().Set("Allow", allowedMethods)
 	http.Error(w, message, code)
 }
 
+// handleOptions responds to an OPTIONS request with the allowed methods
+func handleOptions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Allow", allowedMethods)
+	w.WriteHeader(http.StatusNoContent)
+}
+
 type loggingResponseWriter struct {
 	http.ResponseWriter
 	statusCode int
+	bytes      int64
 }
 
 func (lrw *loggingResponseWriter) WriteHeader(code int) {
@@ -27,26 +82,85 @@ func (lrw *loggingResponseWriter) WriteHeader(code int) {
 	lrw.ResponseWriter.WriteHeader(code)
 }
 
-// getHandler restricts requests to use the GET handler
-func getHandler(h func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+func (lrw *loggingResponseWriter) Write(b []byte) (int, error) {
+	n, err := lrw.ResponseWriter.Write(b)
+	lrw.bytes += int64(n)
+	return n, err
+}
+
+// headResponseWriter discards the response body, so that a HEAD request
+// can be served by the GET handler.
+type headResponseWriter struct {
+	http.ResponseWriter
+}
+
+func (hrw headResponseWriter) Write(b []byte) (int, error) {
+	// Send the same Content-Type that the GET response would have
+	h := hrw.Header()
+	if _, ok := h["Content-Type"]; !ok {
+		h.Set("Content-Type", http.DetectContentType(b))
+	}
+	return len(b), nil
+}
+
+// getHandler restricts requests to use the GET handler. HEAD requests are
+// served by the same handler with the body discarded, and OPTIONS requests
+// are answered with the list of allowed methods. Every request is recorded
+// in the access log, and counted in the metrics under the given route.
+func (s *Server) getHandler(route string, h func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
-		lrw := &loggingResponseWriter{w, http.StatusOK}
+		s.metrics.startRequest()
+		lrw := &loggingResponseWriter{w, http.StatusOK, 0}
 
-		if r.Method == http.MethodGet {
+		// Use the same configuration for the entire request, even if it
+		// is changed while the request is being handled
+		c := s.config()
+		info := &requestInfo{cfg: c, goGet: isGoGet(r)}
+		info.remote, info.host, info.proto, info.forwarded = c.clientInfo(r)
+		r = r.WithContext(context.WithValue(r.Context(), requestInfoKey, info))
+
+		switch r.Method {
+		case http.MethodGet:
 			h(lrw, r)
-		} else {
+
+		case http.MethodHead:
+			h(headResponseWriter{lrw}, r)
+
+		case http.MethodOptions:
+			handleOptions(lrw, r)
+
+		default:
 			// Respond with the error message
 			methodNotAllowed(lrw, r)
 		}
 
-		t := time.Now()
-		elapsed := t.Sub(start)
-		remote := r.Header.Get("X-Forwarded-For")
-		if remote == "" {
-			remote = r.RemoteAddr
+		s.metrics.endRequest(route, lrw.statusCode, info.goGet)
+		if c.accessLog == nil {
+			return
 		}
-		log.Printf("%v \"%v %v %v\" %d %v", remote, r.Method, r.URL.Path, r.Proto, lrw.statusCode, elapsed)
+
+		uri := r.RequestURI
+		if uri == "" {
+			uri = r.URL.RequestURI()
+		}
+
+		c.accessLog.LogAccess(&AccessEntry{
+			Time:      start,
+			Remote:    info.remote,
+			Host:      info.host,
+			Method:    r.Method,
+			URI:       uri,
+			Proto:     r.Proto,
+			Status:    lrw.statusCode,
+			Bytes:     lrw.bytes,
+			Duration:  time.Since(start),
+			Referer:   r.Referer(),
+			UserAgent: r.UserAgent(),
+			GoGet:     info.goGet,
+			CacheHit:  info.cacheHit,
+			Upstream:  info.upstream,
+		})
 	}
 }
 
@@ -57,7 +171,25 @@ func handleRobots(w http.ResponseWriter, r *http.Request) {
 
 // ShutDown shuts down the HTTP server and gracefully exits
 func (s *Server) ShutDown() {
-	if err := s.httpServer.Shutdown(context.Background()); err != nil {
+	// The servers are shut down without holding the lock, since requests
+	// in progress may need it to change the configuration
+	s.mu.Lock()
+	httpServer, metricsServer, adminServer := s.httpServer, s.metricsServer, s.adminServer
+	s.mu.Unlock()
+
+	if metricsServer != nil {
+		if err := metricsServer.Shutdown(context.Background()); err != nil {
+			log.Print(err)
+		}
+	}
+
+	if adminServer != nil {
+		if err := adminServer.Shutdown(context.Background()); err != nil {
+			log.Print(err)
+		}
+	}
+
+	if err := httpServer.Shutdown(context.Background()); err != nil {
 		log.Fatal(err)
 	}
 }
@@ -0,0 +1,184 @@
+// Copyright 2019 Nirenjan Krishnan. All rights reserved.
+
+package vanity
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// upstreamBuckets are the upper bounds in seconds of the upstream latency
+// histogram buckets
+var upstreamBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// requestKey is the set of labels for the request counter
+type requestKey struct {
+	route string
+	code  int
+}
+
+// metrics collects the server statistics, which are exported in the
+// Prometheus text exposition format.
+type metrics struct {
+	// inFlight is the number of requests currently being served. It is
+	// accessed atomically, and must be the first field to guarantee
+	// 64-bit alignment.
+	inFlight int64
+
+	mu sync.Mutex
+
+	// requests counts the requests by route and status code
+	requests map[requestKey]uint64
+
+	// goGet and browser count the requests with and without go-get=1
+	goGet   uint64
+	browser uint64
+
+	// cacheHits and cacheMisses count the lookups of the upstream cache
+	cacheHits   uint64
+	cacheMisses uint64
+
+	// upstream counts the results of the upstream checks
+	upstream map[string]uint64
+
+	// upstreamCounts is the number of upstream checks in each latency
+	// bucket, with the last one counting the checks that exceeded the
+	// largest bucket.
+	upstreamCounts [12]uint64
+	upstreamSum    float64
+}
+
+// startRequest and endRequest track the number of in-flight requests
+func (m *metrics) startRequest() {
+	atomic.AddInt64(&m.inFlight, 1)
+}
+
+func (m *metrics) endRequest(route string, code int, goGet bool) {
+	atomic.AddInt64(&m.inFlight, -1)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.requests == nil {
+		m.requests = make(map[requestKey]uint64)
+	}
+	m.requests[requestKey{route, code}]++
+
+	if goGet {
+		m.goGet++
+	} else {
+		m.browser++
+	}
+}
+
+// observeCache records the result of an upstream cache lookup
+func (m *metrics) observeCache(hit bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if hit {
+		m.cacheHits++
+	} else {
+		m.cacheMisses++
+	}
+}
+
+// observeUpstream records the latency and result of an upstream check
+func (m *metrics) observeUpstream(d time.Duration, exists bool, code int) {
+	result := "not_found"
+	if exists {
+		result = "found"
+	} else if code >= 500 {
+		result = "error"
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.upstream == nil {
+		m.upstream = make(map[string]uint64)
+	}
+	m.upstream[result]++
+
+	secs := d.Seconds()
+	i := sort.SearchFloat64s(upstreamBuckets, secs)
+	m.upstreamCounts[i]++
+	m.upstreamSum += secs
+}
+
+// formatFloat formats the value as expected by Prometheus
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// writeTo writes all the metrics in the Prometheus text exposition format
+func (m *metrics) writeTo(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	header := func(name, kind, help string) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+
+	header("vanity_requests_total", "counter", "Total number of HTTP requests by route and status code.")
+	keys := make([]requestKey, 0, len(m.requests))
+	for k := range m.requests {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].route != keys[j].route {
+			return keys[i].route < keys[j].route
+		}
+		return keys[i].code < keys[j].code
+	})
+	for _, k := range keys {
+		fmt.Fprintf(w, "vanity_requests_total{route=%q,code=\"%d\"} %d\n", k.route, k.code, m.requests[k])
+	}
+
+	header("vanity_client_requests_total", "counter", "Total number of HTTP requests from go-get and browser clients.")
+	fmt.Fprintf(w, "vanity_client_requests_total{client=\"go-get\"} %d\n", m.goGet)
+	fmt.Fprintf(w, "vanity_client_requests_total{client=\"browser\"} %d\n", m.browser)
+
+	header("vanity_requests_in_flight", "gauge", "Number of HTTP requests currently being served.")
+	fmt.Fprintf(w, "vanity_requests_in_flight %d\n", atomic.LoadInt64(&m.inFlight))
+
+	header("vanity_cache_hits_total", "counter", "Total number of upstream checks answered from the cache.")
+	fmt.Fprintf(w, "vanity_cache_hits_total %d\n", m.cacheHits)
+	header("vanity_cache_misses_total", "counter", "Total number of upstream checks not found in the cache.")
+	fmt.Fprintf(w, "vanity_cache_misses_total %d\n", m.cacheMisses)
+
+	header("vanity_upstream_checks_total", "counter", "Total number of upstream checks by result.")
+	for _, result := range []string{"found", "not_found", "error"} {
+		fmt.Fprintf(w, "vanity_upstream_checks_total{result=%q} %d\n", result, m.upstream[result])
+	}
+
+	header("vanity_upstream_duration_seconds", "histogram", "Latency of the upstream checks.")
+	var count uint64
+	for i, le := range upstreamBuckets {
+		count += m.upstreamCounts[i]
+		fmt.Fprintf(w, "vanity_upstream_duration_seconds_bucket{le=%q} %d\n", formatFloat(le), count)
+	}
+	count += m.upstreamCounts[len(upstreamBuckets)]
+	fmt.Fprintf(w, "vanity_upstream_duration_seconds_bucket{le=\"+Inf\"} %d\n", count)
+	fmt.Fprintf(w, "vanity_upstream_duration_seconds_sum %s\n", formatFloat(m.upstreamSum))
+	fmt.Fprintf(w, "vanity_upstream_duration_seconds_count %d\n", count)
+}
+
+// MetricsHandler returns an http.Handler which serves the server metrics
+// in the Prometheus text exposition format. This can be used to serve the
+// metrics from an application specific endpoint.
+func (s *Server) MetricsHandler() http.Handler {
+	return http.HandlerFunc(s.handleMetrics)
+}
+
+// handleMetrics serves the metrics in the Prometheus text format
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	s.metrics.writeTo(w)
+}
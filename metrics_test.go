@@ -0,0 +1,141 @@
+// Copyright 2019 Nirenjan Krishnan. All rights reserved.
+
+package vanity
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	mock := mockServer(t)
+	defer mock.Close()
+
+	s, _ := NewServer("base", mockAddr(mock), "")
+	s.client = mock.Client()
+	s.AccessLog(nil)
+	s.CacheTTL(time.Minute)
+
+	handler := s.getHandler("/", s.handleGeneric)
+	for _, path := range []string{"/valid?go-get=1", "/valid", "/invalid", "/invalid?go-get=1"} {
+		handler(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	var buf bytes.Buffer
+	s.metrics.writeTo(&buf)
+	out := buf.String()
+
+	expected := []string{
+		"# TYPE vanity_requests_total counter\n",
+		"vanity_requests_total{route=\"/\",code=\"200\"} 1\n",
+		"vanity_requests_total{route=\"/\",code=\"302\"} 1\n",
+		"vanity_requests_total{route=\"/\",code=\"404\"} 2\n",
+		"vanity_client_requests_total{client=\"go-get\"} 2\n",
+		"vanity_client_requests_total{client=\"browser\"} 2\n",
+		"vanity_requests_in_flight 0\n",
+		"vanity_cache_hits_total 2\n",
+		"vanity_cache_misses_total 2\n",
+		"vanity_upstream_checks_total{result=\"found\"} 1\n",
+		"vanity_upstream_checks_total{result=\"not_found\"} 1\n",
+		"vanity_upstream_checks_total{result=\"error\"} 0\n",
+		"# TYPE vanity_upstream_duration_seconds histogram\n",
+		"vanity_upstream_duration_seconds_bucket{le=\"+Inf\"} 2\n",
+		"vanity_upstream_duration_seconds_count 2\n",
+	}
+
+	for _, e := range expected {
+		if !strings.Contains(out, e) {
+			t.Errorf("Missing %#v in metrics output:\n%s", e, out)
+		}
+	}
+}
+
+func TestMetricsHistogram(t *testing.T) {
+	var m metrics
+
+	m.observeUpstream(time.Millisecond*3, true, http.StatusOK)
+	m.observeUpstream(time.Millisecond*300, false, http.StatusServiceUnavailable)
+	m.observeUpstream(time.Second*30, false, http.StatusNotFound)
+
+	var buf bytes.Buffer
+	m.writeTo(&buf)
+	out := buf.String()
+
+	expected := []string{
+		"vanity_upstream_duration_seconds_bucket{le=\"0.005\"} 1\n",
+		"vanity_upstream_duration_seconds_bucket{le=\"0.25\"} 1\n",
+		"vanity_upstream_duration_seconds_bucket{le=\"0.5\"} 2\n",
+		"vanity_upstream_duration_seconds_bucket{le=\"10\"} 2\n",
+		"vanity_upstream_duration_seconds_bucket{le=\"+Inf\"} 3\n",
+		"vanity_upstream_duration_seconds_sum 30.303\n",
+		"vanity_upstream_checks_total{result=\"error\"} 1\n",
+	}
+
+	for _, e := range expected {
+		if !strings.Contains(out, e) {
+			t.Errorf("Missing %#v in metrics output:\n%s", e, out)
+		}
+	}
+}
+
+func TestServeMetricsListener(t *testing.T) {
+	main, _ := net.Listen("tcp", "127.0.0.1:")
+	ml, _ := net.Listen("tcp", "127.0.0.1:")
+
+	s, _ := NewServer("base", "root", "")
+	s.AccessLog(nil)
+	s.QueryRemote(false)
+	s.Listen(main)
+	s.ServeMetrics("metrics", ml)
+
+	done := make(chan error)
+	go func() { done <- s.Serve() }()
+	defer func() {
+		s.ShutDown()
+		<-done
+	}()
+
+	// Wait for the server to start
+	var resp *http.Response
+	var err error
+	for i := 0; i < 50; i++ {
+		resp, err = http.Get("http://" + ml.Addr().String() + "/metrics")
+		if err == nil {
+			break
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), "vanity_requests_in_flight") {
+		t.Errorf("Unexpected metrics response %v: %s", resp.StatusCode, body)
+	}
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Unexpected content type %#v", ct)
+	}
+
+	// The main listener treats /metrics as a regular package
+	client := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err = client.Get("http://" + main.Addr().String() + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Errorf("Unexpected status code %v on main listener, expected %v", resp.StatusCode, http.StatusFound)
+	}
+}
@@ -0,0 +1,265 @@
+// Copyright 2019 Nirenjan Krishnan. All rights reserved.
+
+package vanity
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// maxPathLength is the maximum length of a request path that is accepted
+const maxPathLength = 512
+
+// modulePath is a request path split into its components. A request for
+// `/quote/v3/sub@v3.1.0` is split into the package `quote`, the major
+// version `v3`, the subpath `v3/sub` and the version `v3.1.0`.
+type modulePath struct {
+	// pkg is the first path element, which identifies the repository
+	pkg string
+
+	// subpath is the path after pkg, without the version
+	subpath string
+
+	// major is set if the first element of subpath is a major version
+	// suffix, e.g., `v2`
+	major string
+
+	// version is the version requested with an `@` suffix
+	version string
+}
+
+// isMajorSuffix checks if the path element is a major version suffix of the
+// form vN, with N >= 2
+func isMajorSuffix(elem string) bool {
+	if len(elem) < 2 || elem[0] != 'v' || elem[1] == '0' || elem == "v1" {
+		return false
+	}
+
+	for _, c := range elem[1:] {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// parseModulePath splits the request path into its components. The version
+// is only recognized at the end of a path element, and is removed from the
+// path wherever it appears.
+func parseModulePath(module string) modulePath {
+	var m modulePath
+	var elems []string
+
+	for _, elem := range strings.Split(strings.Trim(module, "/"), "/") {
+		if i := strings.Index(elem, "@"); i >= 0 {
+			if m.version == "" {
+				m.version = elem[i+1:]
+			}
+			elem = elem[:i]
+		}
+		if elem != "" {
+			elems = append(elems, elem)
+		}
+	}
+
+	if len(elems) == 0 {
+		return m
+	}
+
+	m.pkg = elems[0]
+	m.subpath = strings.Join(elems[1:], "/")
+	if len(elems) > 1 && isMajorSuffix(elems[1]) {
+		m.major = elems[1]
+	}
+
+	return m
+}
+
+// path returns the request path without the version
+func (m modulePath) path() string {
+	if m.subpath == "" {
+		return m.pkg
+	}
+	return m.pkg + "/" + m.subpath
+}
+
+// atVersion returns the version with the `@` prefix, or an empty string if
+// there is no version
+func (m modulePath) atVersion() string {
+	if m.version == "" {
+		return ""
+	}
+	return "@" + m.version
+}
+
+// sourceDir returns the directory within the repository for the subpath.
+// The major version suffix is removed, since it is normally not a directory
+// in the repository.
+func (m modulePath) sourceDir() string {
+	if m.major == "" {
+		return m.subpath
+	}
+
+	return strings.TrimPrefix(strings.TrimPrefix(m.subpath, m.major), "/")
+}
+
+// pathError is an error for an invalid request path, along with the HTTP
+// status code to respond with.
+type pathError struct {
+	code int
+	msg  string
+}
+
+func (e *pathError) Error() string {
+	return e.msg
+}
+
+func badPath(format string, args ...interface{}) *pathError {
+	return &pathError{http.StatusBadRequest, fmt.Sprintf(format, args...)}
+}
+
+func missingPath(format string, args ...interface{}) *pathError {
+	return &pathError{http.StatusNotFound, fmt.Sprintf(format, args...)}
+}
+
+// reservedNames are the path elements which cannot be used in a module path,
+// since they are reserved file names on Windows.
+var reservedNames = map[string]bool{
+	"con": true, "prn": true, "aux": true, "nul": true,
+	"com1": true, "com2": true, "com3": true, "com4": true, "com5": true,
+	"com6": true, "com7": true, "com8": true, "com9": true,
+	"lpt1": true, "lpt2": true, "lpt3": true, "lpt4": true, "lpt5": true,
+	"lpt6": true, "lpt7": true, "lpt8": true, "lpt9": true,
+}
+
+// checkVersion checks that the version only contains the characters that
+// may be used in a version query, e.g., `v1.2.3-pre+meta` or `master`.
+func checkVersion(v string) *pathError {
+	if v == "" {
+		return badPath("empty version")
+	}
+
+	for _, c := range v {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-' || c == '.' || c == '+' || c == '_':
+		default:
+			return badPath("invalid character %q in version", c)
+		}
+	}
+
+	return nil
+}
+
+// checkElem checks a single path element against the rules for Go module
+// paths. Uppercase characters are valid in Go, but are not accepted since
+// the vanity packages are always lowercase.
+func checkElem(elem string) *pathError {
+	if elem == "" {
+		return badPath("empty path element")
+	}
+	if elem == "." || strings.Contains(elem, "..") {
+		return badPath("invalid path element %q", elem)
+	}
+	if elem[0] == '.' || elem[len(elem)-1] == '.' {
+		return badPath("leading or trailing dot in path element %q", elem)
+	}
+
+	for _, c := range elem {
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9':
+		case c == '-' || c == '.' || c == '_' || c == '~':
+		case c >= 'A' && c <= 'Z':
+			return missingPath("uppercase character in path element %q", elem)
+		default:
+			return badPath("invalid character %q in path element", c)
+		}
+	}
+
+	short := elem
+	if i := strings.Index(short, "."); i >= 0 {
+		short = short[:i]
+	}
+	if reservedNames[short] {
+		return missingPath("reserved path element %q", elem)
+	}
+
+	// Disallow names like `abcdef~1`, which are Windows short names
+	if i := strings.LastIndex(short, "~"); i >= 0 && i < len(short)-1 {
+		suffix := short[i+1:]
+		if strings.Trim(suffix, "0123456789") == "" {
+			return badPath("invalid short name in path element %q", elem)
+		}
+	}
+
+	return nil
+}
+
+// checkPackageName checks the first path element, which is the name of the
+// package. In addition to the rules for path elements, it must not start
+// with a dash, or end with `.git`.
+func checkPackageName(name string) *pathError {
+	if err := checkElem(name); err != nil {
+		return err
+	}
+	if name[0] == '-' {
+		return badPath("leading dash in package %q", name)
+	}
+	if strings.HasSuffix(name, ".git") {
+		return missingPath("package %q has a .git suffix", name)
+	}
+
+	return nil
+}
+
+// checkModulePath validates the escaped request path, before it is used to
+// query the upstream. It follows the rules for Go module paths, with an
+// optional `@version` suffix on one element. Escaped characters are never
+// valid, since none of the allowed characters need escaping.
+func checkModulePath(escaped string) error {
+	if len(escaped) > maxPathLength {
+		return badPath("path too long")
+	}
+	if strings.Contains(escaped, "%") {
+		return badPath("escaped characters in path")
+	}
+
+	p := strings.TrimPrefix(escaped, "/")
+	p = strings.TrimSuffix(p, "/")
+	if p == "" {
+		return badPath("empty path")
+	}
+
+	if strings.Count(p, "@") > 1 {
+		return badPath("multiple versions in path")
+	}
+
+	for i, elem := range strings.Split(p, "/") {
+		if at := strings.Index(elem, "@"); at >= 0 {
+			if err := checkVersion(elem[at+1:]); err != nil {
+				return err
+			}
+			elem = elem[:at]
+		}
+
+		check := checkElem
+		if i == 0 {
+			check = checkPackageName
+		}
+		if err := check(elem); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// pathErrorCode returns the HTTP status code for the path validation error
+func pathErrorCode(err error) int {
+	if pe, ok := err.(*pathError); ok {
+		return pe.code
+	}
+	return http.StatusBadRequest
+}
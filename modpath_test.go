@@ -0,0 +1,228 @@
+// Copyright 2019 Nirenjan Krishnan. All rights reserved.
+
+package vanity
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestParseModulePath(t *testing.T) {
+	checks := []struct {
+		in  string
+		out modulePath
+	}{
+		{"/", modulePath{}},
+		{"/foo", modulePath{pkg: "foo"}},
+		{"/foo@v1.2.3", modulePath{pkg: "foo", version: "v1.2.3"}},
+		{"/foo/sub@v1.2.3", modulePath{pkg: "foo", subpath: "sub", version: "v1.2.3"}},
+		{"/foo@v1.2.3/sub", modulePath{pkg: "foo", subpath: "sub", version: "v1.2.3"}},
+		{"/foo/v2/sub", modulePath{pkg: "foo", subpath: "v2/sub", major: "v2"}},
+		{"/foo/v2@v2.0.1", modulePath{pkg: "foo", subpath: "v2", major: "v2", version: "v2.0.1"}},
+		{"/foo/v1/sub", modulePath{pkg: "foo", subpath: "v1/sub"}},
+		{"/foo/v0", modulePath{pkg: "foo", subpath: "v0"}},
+		{"/foo/vx/", modulePath{pkg: "foo", subpath: "vx"}},
+		{"/foo@latest", modulePath{pkg: "foo", version: "latest"}},
+	}
+
+	for _, c := range checks {
+		if res := parseModulePath(c.in); res != c.out {
+			t.Errorf("Mismatch in parseModulePath(%v), expected %#v, got %#v", c.in, c.out, res)
+		}
+	}
+}
+
+func TestModulePathParts(t *testing.T) {
+	m := parseModulePath("/foo/v2/sub/dir@v2.1.0")
+
+	if m.path() != "foo/v2/sub/dir" || m.atVersion() != "@v2.1.0" || m.sourceDir() != "sub/dir" {
+		t.Errorf("Unexpected module path parts %#v %#v %#v", m.path(), m.atVersion(), m.sourceDir())
+	}
+
+	m = parseModulePath("/foo")
+	if m.path() != "foo" || m.atVersion() != "" || m.sourceDir() != "" {
+		t.Errorf("Unexpected module path parts %#v %#v %#v", m.path(), m.atVersion(), m.sourceDir())
+	}
+}
+
+func TestVersionedRedirects(t *testing.T) {
+	checks := []struct {
+		redirect string
+		module   string
+		exp      string
+	}{
+		{"https://pkg.go.dev/nirenjan.org", "/semver@v1.2.3", "https://pkg.go.dev/nirenjan.org/semver@v1.2.3"},
+		{"https://pkg.go.dev/nirenjan.org", "/semver@v1.2.3/core", "https://pkg.go.dev/nirenjan.org/semver/core@v1.2.3"},
+		{"https://pkg.go.dev/nirenjan.org", "/semver/v2/core", "https://pkg.go.dev/nirenjan.org/semver/v2/core"},
+		{"https://pkg.go.dev/{importpath}{atversion}", "/semver/core@v1.2.3", "https://pkg.go.dev/nirenjan.org/semver/core@v1.2.3"},
+		{"https://docs.example.com/{pkg}/{version}/", "/semver@v1.2.3", "https://docs.example.com/semver/v1.2.3/"},
+		{"", "/semver@v1.2.3", "https://github.com/nirenjan/semver/tree/v1.2.3"},
+		{"", "/semver/v2/core@v2.0.0", "https://github.com/nirenjan/semver/tree/v2.0.0/core"},
+		{"", "/semver/core", "https://github.com/nirenjan/semver"},
+	}
+
+	for _, c := range checks {
+		s, _ := NewServer("nirenjan.org", "https://github.com/nirenjan/", c.redirect)
+		s.Repo().SetProvider("github")
+
+		if res := s.config().getRedirect(c.module, ""); res != c.exp {
+			t.Errorf("Mismatch in getRedirect(%v) with redirect %#v, expected %#v, got %#v",
+				c.module, c.redirect, c.exp, res)
+		}
+	}
+}
+
+func TestVersionedMeta(t *testing.T) {
+	s, _ := NewServer("nirenjan.org", "https://github.com/nirenjan/", "https://pkg.go.dev/nirenjan.org")
+	s.QueryRemote(false)
+	s.Repo().SetProvider("github")
+	s.LandingPages(true, 0)
+
+	rr := httptest.NewRecorder()
+	s.handleGeneric(rr, httptest.NewRequest("GET", "/semver/core@v1.2.3", nil))
+	body := rr.Body.String()
+
+	expected := []string{
+		`<meta name="go-import" content="nirenjan.org/semver git https://github.com/nirenjan/semver">`,
+		`<pre>go get nirenjan.org/semver/core@v1.2.3</pre>`,
+		`<a href="https://github.com/nirenjan/semver/tree/v1.2.3/core">`,
+		`<a href="https://pkg.go.dev/nirenjan.org/semver/core@v1.2.3">`,
+	}
+	for _, e := range expected {
+		if !strings.Contains(body, e) {
+			t.Errorf("Missing %#v in landing page:\n%s", e, body)
+		}
+	}
+}
+
+func TestCheckModulePath(t *testing.T) {
+	checks := []struct {
+		in   string
+		code int
+	}{
+		{"/semver", 0},
+		{"/semver/", 0},
+		{"/go-semver/v2/core", 0},
+		{"/semver@v1.2.3-pre+meta/core", 0},
+		{"/semver/core@master", 0},
+		{"/a.b_c~d", 0},
+		{"/", 400},
+		{"//semver", 400},
+		{"/semver//core", 400},
+		{"/semver/../etc", 400},
+		{"/./semver", 400},
+		{"/.semver", 400},
+		{"/semver./core", 400},
+		{"/semver%2fcore", 400},
+		{"/%73emver", 400},
+		{"/sémver", 400},
+		{"/semver core", 400},
+		{"/semver@", 400},
+		{"/semver@v1@v2", 400},
+		{"/semver@v1/core@v2", 400},
+		{"/semver@v1;rm", 400},
+		{"/-semver", 400},
+		{"/semver~1", 400},
+		{"/" + strings.Repeat("a", maxPathLength), 400},
+		{"/SemVer", 404},
+		{"/semver/Core", 404},
+		{"/semver.git", 404},
+		{"/semver.git@v1.0.0", 404},
+		{"/con", 404},
+		{"/semver/aux.go", 404},
+	}
+
+	for _, c := range checks {
+		err := checkModulePath(c.in)
+		code := 0
+		if err != nil {
+			code = pathErrorCode(err)
+		}
+		if code != c.code {
+			t.Errorf("Mismatch in checkModulePath(%v), expected %v, got %v (%v)", c.in, c.code, code, err)
+		}
+	}
+}
+
+func TestInvalidPathNoUpstream(t *testing.T) {
+	var count int32
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&count, 1)
+	}))
+	defer mock.Close()
+
+	s, _ := NewServer("nirenjan.org", mock.URL+"/", "")
+	s.QueryRemote(true)
+	s.client = mock.Client()
+
+	checks := []struct {
+		path string
+		code int
+	}{
+		{"/semver%2F..%2Fadmin?go-get=1", 400},
+		{"/SEMVER?go-get=1", 404},
+		{"/semver.git?go-get=1", 404},
+		{"/semver@v1@v2", 400},
+		{"/semver?go-get=1", 200},
+	}
+
+	for _, c := range checks {
+		atomic.StoreInt32(&count, 0)
+		req := httptest.NewRequest("GET", c.path, nil)
+		rr := httptest.NewRecorder()
+		s.handleGeneric(rr, req)
+
+		if rr.Code != c.code {
+			t.Errorf("Mismatch in status for %v, expected %v, got %v", c.path, c.code, rr.Code)
+		}
+
+		exp := int32(0)
+		if c.code == 200 {
+			exp = 1
+		}
+		if n := atomic.LoadInt32(&count); n != exp {
+			t.Errorf("Mismatch in upstream requests for %v, expected %v, got %v", c.path, exp, n)
+		}
+	}
+}
+
+func FuzzCheckModulePath(f *testing.F) {
+	for _, seed := range []string{
+		"/semver", "/semver/v2/core@v2.0.1", "/semver/../x", "/semver%2fx",
+		"/SemVer", "/semver.git", "/a@b@c", "/\u00e9", "//", "/con.txt",
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, in string) {
+		// Request paths always start with a slash
+		if !strings.HasPrefix(in, "/") || checkModulePath(in) != nil {
+			return
+		}
+
+		// Accepted paths must only contain safe characters, and parse into
+		// a package that is itself valid
+		for _, c := range in {
+			if c > 0x7e || c <= ' ' || strings.ContainsRune("%?#\\\"'<>", c) {
+				t.Fatalf("Accepted path %q with character %q", in, c)
+			}
+		}
+		if strings.Contains(in, "..") || strings.Contains(in, "//") {
+			t.Fatalf("Accepted path %q with empty or dot elements", in)
+		}
+
+		m := parseModulePath(in)
+		if m.pkg == "" || m.pkg != repoBase(in) {
+			t.Fatalf("Accepted path %q with package %q, base %q", in, m.pkg, repoBase(in))
+		}
+		if err := checkPackageName(m.pkg); err != nil {
+			t.Fatalf("Accepted path %q with invalid package: %v", in, err)
+		}
+		if err := checkModulePath("/" + m.path()); err != nil {
+			t.Fatalf("Accepted path %q, but not its unversioned path: %v", in, err)
+		}
+	})
+}
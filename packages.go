@@ -0,0 +1,107 @@
+// Copyright 2019 Nirenjan Krishnan. All rights reserved.
+
+package vanity
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Package describes a single vanity package which is known to the server.
+// Packages which are not explicitly added are still served, using the
+// repository derived from the VCS root.
+type Package struct {
+	// Name is the first path element of the import path, e.g., `quote`
+	// for the package `rsc.io/quote`.
+	Name string `json:"name"`
+
+	// Repo is the URL of the repository. If this is empty, it defaults to
+	// the VCS root followed by the name.
+	Repo string `json:"repo,omitempty"`
+
+	// Description is a short human readable description of the package.
+	Description string `json:"description,omitempty"`
+
+	// Docs is the URL of the package documentation. If this is empty, it
+	// defaults to the browser redirect for the package.
+	Docs string `json:"docs,omitempty"`
+
+	// Version is the latest known version of the package, e.g., `v1.2.3`
+	Version string `json:"version,omitempty"`
+}
+
+// AddPackage adds the package to the list of known packages, replacing any
+// existing package with the same name.
+func (s *Server) AddPackage(p Package) error {
+	p, err := cleanPackage(p)
+	if err != nil {
+		return err
+	}
+
+	return s.update(func(c *config) error {
+		c.packages[p.Name] = p
+		return nil
+	})
+}
+
+// cleanPackage normalizes the name and repository of the package, and checks
+// that the name is valid
+func cleanPackage(p Package) (Package, error) {
+	p.Name = strings.Trim(strings.TrimSpace(p.Name), "/")
+	if err := checkPackageName(p.Name); err != nil {
+		return p, fmt.Errorf("Invalid package name %#v: %v", p.Name, err)
+	}
+	p.Repo = strings.TrimSuffix(strings.TrimSpace(p.Repo), "/")
+
+	return p, nil
+}
+
+// RemovePackage removes the package from the list of known packages
+func (s *Server) RemovePackage(name string) {
+	s.update(func(c *config) error {
+		delete(c.packages, name)
+		return nil
+	})
+}
+
+// Packages returns the list of known packages, sorted by name
+func (s *Server) Packages() []Package {
+	return s.config().sortedPackages()
+}
+
+// sortedPackages returns the list of known packages, sorted by name
+func (c *config) sortedPackages() []Package {
+	list := make([]Package, 0, len(c.packages))
+	for _, p := range c.packages {
+		list = append(list, p)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// repoURL returns the repository URL for the package with the given name.
+// The empty name refers to the root repository, if one is configured.
+func (c *config) repoURL(name string) string {
+	if name == "" && c.rootRepo != "" {
+		return c.rootRepo
+	}
+
+	if p, ok := c.packages[name]; ok && p.Repo != "" {
+		return p.Repo
+	}
+
+	return c.repo.root + name
+}
+
+// docsURL returns the documentation URL for the package with the given name
+func (c *config) docsURL(name string) string {
+	if p, ok := c.packages[name]; ok && p.Docs != "" {
+		return p.Docs
+	}
+
+	return c.getRedirect("/"+name, "")
+}
@@ -0,0 +1,77 @@
+// Copyright 2019 Nirenjan Krishnan. All rights reserved.
+
+package vanity
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAddPackage(t *testing.T) {
+	s, _ := NewServer("nirenjan.org", "https://github.com/nirenjan/go-", "")
+
+	checks := []struct {
+		pkg Package
+		ok  bool
+	}{
+		{Package{Name: "semver"}, true},
+		{Package{Name: "/vanity/", Repo: "https://git.example.com/vanity/"}, true},
+		{Package{Name: ""}, false},
+		{Package{Name: "a/b"}, false},
+		{Package{Name: "Upper"}, false},
+		{Package{Name: "repo.git"}, false},
+		{Package{Name: "-flag"}, false},
+	}
+
+	for _, c := range checks {
+		err := s.AddPackage(c.pkg)
+		if (err == nil) != c.ok {
+			t.Errorf("Mismatch in AddPackage(%#v), expected success %v, got %v", c.pkg, c.ok, err)
+		}
+	}
+
+	list := s.Packages()
+	if len(list) != 2 || list[0].Name != "semver" || list[1].Name != "vanity" {
+		t.Fatalf("Unexpected package list %#v", list)
+	}
+
+	urls := []struct {
+		name string
+		repo string
+	}{
+		{"semver", "https://github.com/nirenjan/go-semver"},
+		{"vanity", "https://git.example.com/vanity"},
+		{"unknown", "https://github.com/nirenjan/go-unknown"},
+	}
+	for _, u := range urls {
+		if repo := s.config().repoURL(u.name); repo != u.repo {
+			t.Errorf("Mismatch in repoURL(%v), expected %#v, got %#v", u.name, u.repo, repo)
+		}
+	}
+
+	s.RemovePackage("semver")
+	if list := s.Packages(); len(list) != 1 {
+		t.Errorf("Unexpected package list after removal %#v", list)
+	}
+}
+
+func TestPackageMeta(t *testing.T) {
+	s, _ := NewServer("nirenjan.org", "https://github.com/nirenjan/go-", "")
+	s.QueryRemote(false)
+	s.AddPackage(Package{Name: "vanity", Repo: "https://git.example.com/vanity"})
+
+	rr := httptest.NewRecorder()
+	s.handleGeneric(rr, httptest.NewRequest("GET", "/vanity/cmd?go-get=1", nil))
+
+	expected := `<meta name="go-import" content="nirenjan.org/vanity git https://git.example.com/vanity">`
+	if !strings.Contains(rr.Body.String(), expected) {
+		t.Errorf("Missing %#v in response:\n%s", expected, rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	s.handleGeneric(rr, httptest.NewRequest("GET", "/vanity/cmd", nil))
+	if loc := rr.Header().Get("Location"); loc != "https://git.example.com/vanity" {
+		t.Errorf("Unexpected redirect %#v", loc)
+	}
+}
@@ -0,0 +1,244 @@
+// Copyright 2019 Nirenjan Krishnan. All rights reserved.
+
+package vanity
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// proxies is the list of trusted reverse proxies. Forwarding headers are
+// only honored on requests received directly from one of these.
+type proxies struct {
+	// nets is the list of trusted networks
+	nets []*net.IPNet
+
+	// unix is set if peers connecting over a Unix socket are trusted
+	unix bool
+}
+
+// parseProxies parses a list of CIDRs or IP addresses. The special value
+// `unix` trusts all connections received on a Unix domain socket.
+func parseProxies(list []string) (proxies, error) {
+	var p proxies
+
+	for _, entry := range list {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		if strings.ToLower(entry) == "unix" {
+			p.unix = true
+			continue
+		}
+
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return proxies{}, fmt.Errorf("Invalid trusted proxy %v", entry)
+			}
+
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+			p.nets = append(p.nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, n, err := net.ParseCIDR(entry)
+		if err != nil {
+			return proxies{}, fmt.Errorf("Invalid trusted proxy %v", entry)
+		}
+		p.nets = append(p.nets, n)
+	}
+
+	return p, nil
+}
+
+// String returns the comma separated list of trusted proxies
+func (p proxies) String() string {
+	var list []string
+	for _, n := range p.nets {
+		list = append(list, n.String())
+	}
+	if p.unix {
+		list = append(list, "unix")
+	}
+
+	return strings.Join(list, ", ")
+}
+
+// trusted checks if the address, which may or may not include a port, is a
+// trusted proxy.
+func (p proxies) trusted(addr string) bool {
+	if addr == "" || addr == "@" {
+		// Connections over Unix sockets have no remote address
+		return p.unix
+	}
+
+	ip := net.ParseIP(stripPort(addr))
+	if ip == nil {
+		return false
+	}
+
+	for _, n := range p.nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// stripPort removes the port and brackets from an IP address, if present
+func stripPort(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+
+	return strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+}
+
+// forwardedHop is a single element of the Forwarded header, or the
+// equivalent X-Forwarded-* headers
+type forwardedHop struct {
+	addr  string
+	host  string
+	proto string
+}
+
+// parseForwarded parses the RFC 7239 Forwarded header values into a list
+// of hops, ordered from the client to the nearest proxy.
+func parseForwarded(values []string) []forwardedHop {
+	var hops []forwardedHop
+
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			var hop forwardedHop
+
+			for _, pair := range strings.Split(element, ";") {
+				kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+				if len(kv) != 2 {
+					continue
+				}
+
+				v := strings.Trim(strings.TrimSpace(kv[1]), "\"")
+				switch strings.ToLower(strings.TrimSpace(kv[0])) {
+				case "for":
+					hop.addr = stripPort(v)
+				case "host":
+					hop.host = v
+				case "proto":
+					hop.proto = strings.ToLower(v)
+				}
+			}
+
+			hops = append(hops, hop)
+		}
+	}
+
+	return hops
+}
+
+// parseXForwarded converts the X-Forwarded-For, X-Forwarded-Host and
+// X-Forwarded-Proto headers into a list of hops. The host and protocol are
+// assigned to the hop added by the nearest proxy.
+func parseXForwarded(h http.Header) []forwardedHop {
+	var hops []forwardedHop
+
+	for _, value := range h["X-Forwarded-For"] {
+		for _, addr := range strings.Split(value, ",") {
+			hops = append(hops, forwardedHop{addr: stripPort(strings.TrimSpace(addr))})
+		}
+	}
+
+	if len(hops) == 0 {
+		hops = append(hops, forwardedHop{})
+	}
+
+	last := &hops[len(hops)-1]
+	last.host = lastListValue(h.Get("X-Forwarded-Host"))
+	last.proto = strings.ToLower(lastListValue(h.Get("X-Forwarded-Proto")))
+
+	return hops
+}
+
+// lastListValue returns the last value in a comma separated list
+func lastListValue(v string) string {
+	list := strings.Split(v, ",")
+	return strings.TrimSpace(list[len(list)-1])
+}
+
+// clientInfo determines the client address, and the host and protocol
+// that the client used to make the request. Forwarding headers are only
+// used if the request was received from a trusted proxy, and the chain is
+// followed back through the trusted proxies until the first untrusted hop.
+// forwarded is set if both the host and the protocol were given by a
+// trusted proxy, rather than taken from the request itself.
+func (c *config) clientInfo(r *http.Request) (remote, host, proto string, forwarded bool) {
+	remote = r.RemoteAddr
+	host = r.Host
+	proto = "http"
+	if r.TLS != nil {
+		proto = "https"
+	}
+
+	if !c.proxies.trusted(r.RemoteAddr) {
+		return
+	}
+
+	var hops []forwardedHop
+	if fwd, ok := r.Header["Forwarded"]; ok {
+		hops = parseForwarded(fwd)
+	} else {
+		hops = parseXForwarded(r.Header)
+	}
+
+	var fwdHost, fwdProto bool
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := hops[i]
+		if hop.host != "" {
+			host = hop.host
+			fwdHost = true
+		}
+		if hop.proto == "http" || hop.proto == "https" {
+			proto = hop.proto
+			fwdProto = true
+		}
+		if hop.addr == "" {
+			break
+		}
+
+		remote = hop.addr
+		if !c.proxies.trusted(hop.addr) {
+			break
+		}
+	}
+
+	forwarded = fwdHost && fwdProto
+	return
+}
+
+// absoluteURL converts a redirect target without a scheme and host into an
+// absolute URL, using the host and protocol given by a trusted proxy. Other
+// targets are left relative, so that the Host header of the request, which
+// the client controls, never ends up in a cacheable redirect, and the
+// protocol is not guessed wrong behind a TLS terminator.
+func absoluteURL(r *http.Request, target string) string {
+	if !strings.HasPrefix(target, "/") || strings.HasPrefix(target, "//") {
+		return target
+	}
+
+	info := getRequestInfo(r)
+	if !info.forwarded || info.host == "" || info.proto == "" {
+		return target
+	}
+
+	return info.proto + "://" + info.host + target
+}
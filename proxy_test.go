@@ -0,0 +1,164 @@
+// Copyright 2019 Nirenjan Krishnan. All rights reserved.
+
+package vanity
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseProxies(t *testing.T) {
+	p, err := parseProxies([]string{"10.0.0.0/8", " 192.168.1.1 ", "::1", "unix", ""})
+	if err != nil {
+		t.Fatalf("Expected nil, got error %v", err)
+	}
+
+	if p.String() != "10.0.0.0/8, 192.168.1.1/32, ::1/128, unix" {
+		t.Errorf("Unexpected trusted proxies %v", p)
+	}
+
+	checks := []struct {
+		addr    string
+		trusted bool
+	}{
+		{"10.1.2.3:4567", true},
+		{"10.1.2.3", true},
+		{"192.168.1.1:80", true},
+		{"192.168.1.2:80", false},
+		{"[::1]:80", true},
+		{"@", true},
+		{"", true},
+		{"unknown", false},
+	}
+
+	for _, c := range checks {
+		if res := p.trusted(c.addr); res != c.trusted {
+			t.Errorf("Mismatch in proxies.trusted(%v), expected %v, got %v", c.addr, c.trusted, res)
+		}
+	}
+
+	for _, invalid := range []string{"10.0.0.0/33", "example.com"} {
+		if _, err := parseProxies([]string{invalid}); err == nil {
+			t.Errorf("Expected error for %v, got nil", invalid)
+		}
+	}
+}
+
+func TestClientInfo(t *testing.T) {
+	s, _ := NewServer("base", "root", "")
+	s.TrustedProxies([]string{"10.0.0.0/8"})
+
+	checks := []struct {
+		remote  string
+		headers map[string]string
+		addr    string
+		host    string
+		proto   string
+		fwd     bool
+	}{
+		// Untrusted peers have their headers ignored
+		{"192.0.2.1:1234", map[string]string{"X-Forwarded-For": "198.51.100.1"}, "192.0.2.1:1234", "example.com", "http", false},
+		{"192.0.2.1:1234", map[string]string{"Forwarded": "for=198.51.100.1;proto=https"}, "192.0.2.1:1234", "example.com", "http", false},
+
+		// Trusted proxy with X-Forwarded-* headers
+		{"10.0.0.1:1234", map[string]string{
+			"X-Forwarded-For":   "198.51.100.1",
+			"X-Forwarded-Host":  "nirenjan.org",
+			"X-Forwarded-Proto": "https",
+		}, "198.51.100.1", "nirenjan.org", "https", true},
+
+		// Spoofed entries to the left of an untrusted hop are ignored
+		{"10.0.0.1:1234", map[string]string{"X-Forwarded-For": "203.0.113.9, 198.51.100.1, 10.0.0.2"}, "198.51.100.1", "example.com", "http", false},
+
+		// All hops trusted, use the leftmost one
+		{"10.0.0.1:1234", map[string]string{"X-Forwarded-For": "10.0.0.3, 10.0.0.2"}, "10.0.0.3", "example.com", "http", false},
+
+		// Forwarded header takes precedence
+		{"10.0.0.1:1234", map[string]string{
+			"Forwarded":       `for=198.51.100.1;host=nirenjan.org;proto=https, for="10.0.0.2:80"`,
+			"X-Forwarded-For": "203.0.113.9",
+		}, "198.51.100.1", "nirenjan.org", "https", true},
+		{"10.0.0.1:1234", map[string]string{"Forwarded": `for="[2001:db8::1]:4711";proto=HTTPS`}, "2001:db8::1", "example.com", "https", false},
+
+		// Trusted proxy without forwarding headers
+		{"10.0.0.1:1234", nil, "10.0.0.1:1234", "example.com", "http", false},
+	}
+
+	for _, c := range checks {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = c.remote
+		for k, v := range c.headers {
+			req.Header.Set(k, v)
+		}
+
+		addr, host, proto, fwd := s.config().clientInfo(req)
+		if addr != c.addr || host != c.host || proto != c.proto || fwd != c.fwd {
+			t.Errorf("Mismatch in clientInfo(%v, %v), expected (%v, %v, %v, %v), got (%v, %v, %v, %v)",
+				c.remote, c.headers, c.addr, c.host, c.proto, c.fwd, addr, host, proto, fwd)
+		}
+	}
+}
+
+func TestForwardedRedirect(t *testing.T) {
+	s, _ := NewServer("base", "root", "")
+	s.AccessLog(nil)
+	s.RootRedirect("/index.html")
+	s.TrustedProxies([]string{"10.0.0.0/8"})
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("X-Forwarded-Host", "nirenjan.org")
+	req.Header.Set("X-Forwarded-Proto", "https")
+
+	rr := httptest.NewRecorder()
+	s.getHandler("/", s.handleGeneric)(rr, req)
+
+	if rr.Code != http.StatusFound {
+		t.Errorf("Unexpected status code %v, expected %v", rr.Code, http.StatusFound)
+	}
+	if loc := rr.Header().Get("Location"); loc != "https://nirenjan.org/index.html" {
+		t.Errorf("Unexpected redirect %#v", loc)
+	}
+}
+
+func TestSpoofedHostRedirect(t *testing.T) {
+	s, _ := NewServer("base", "root", "")
+	s.AccessLog(nil)
+	s.RootRedirect("/about")
+
+	checks := []struct {
+		trusted []string
+		headers map[string]string
+		exp     string
+	}{
+		// Without trusted proxies, the Host header and any forwarding
+		// headers are not used, and the redirect stays relative
+		{nil, nil, "/about"},
+		{nil, map[string]string{"X-Forwarded-Host": "evil.com", "X-Forwarded-Proto": "https"}, "/about"},
+
+		// A trusted proxy must give both the host and the protocol
+		{[]string{"10.0.0.0/8"}, map[string]string{"X-Forwarded-Host": "nirenjan.org"}, "/about"},
+		{[]string{"10.0.0.0/8"}, map[string]string{"X-Forwarded-Proto": "https"}, "/about"},
+		{[]string{"10.0.0.0/8"}, map[string]string{"X-Forwarded-Host": "nirenjan.org", "X-Forwarded-Proto": "https"},
+			"https://nirenjan.org/about"},
+	}
+
+	for _, c := range checks {
+		s.TrustedProxies(c.trusted)
+
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Host = "evil.com"
+		req.RemoteAddr = "10.0.0.1:1234"
+		for k, v := range c.headers {
+			req.Header.Set(k, v)
+		}
+
+		rr := httptest.NewRecorder()
+		s.getHandler("/", s.handleGeneric)(rr, req)
+		if loc := rr.Header().Get("Location"); rr.Code != http.StatusFound || loc != c.exp {
+			t.Errorf("Mismatch in redirect with trusted %v and headers %v, expected %v, got %v %v",
+				c.trusted, c.headers, c.exp, rr.Code, loc)
+		}
+	}
+}
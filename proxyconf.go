@@ -0,0 +1,497 @@
+// Copyright 2019 Nirenjan Krishnan. All rights reserved.
+
+package vanity
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ProxyFormat is the format of a generated reverse proxy configuration
+type ProxyFormat int
+
+const (
+	// ProxyNginx is a set of nginx location blocks
+	ProxyNginx ProxyFormat = iota
+
+	// ProxyCaddy is a set of Caddyfile handle blocks
+	ProxyCaddy
+)
+
+// ParseProxyFormat converts the case-insensitive format name to a
+// ProxyFormat. It can be one of nginx or caddy.
+func ParseProxyFormat(f string) (ProxyFormat, error) {
+	switch strings.TrimSpace(strings.ToLower(f)) {
+	case "nginx":
+		return ProxyNginx, nil
+
+	case "caddy":
+		return ProxyCaddy, nil
+
+	default:
+		return ProxyNginx, fmt.Errorf("Unknown proxy format %v", f)
+	}
+}
+
+// elemPattern matches a path element with the characters allowed by
+// checkElem, without leading or trailing dots or `..`. The proxies match the
+// decoded path, so the captures never contain characters which would need
+// escaping in a header, such as CR and LF.
+const elemPattern = `[a-z0-9_~-]+(?:\.[a-z0-9_~-]+)*`
+
+// pkgPattern matches the name of a package, which also must not start with a
+// dash. The `.git` suffix and reserved names are rejected by invalidPattern.
+const pkgPattern = `[a-z0-9_~][a-z0-9_~-]*(?:\.[a-z0-9_~-]+)*`
+
+// subPattern matches the rest of the path after the package, capturing the
+// subpath and the major version. Paths with a version are not matched.
+const subPattern = `(?P<sub>/(?P<subpath>(?:(?P<major>v(?:[2-9]|[1-9][0-9]+))(?:/|$))?(?:` +
+	elemPattern + `(?:/` + elemPattern + `)*/?)?))?$`
+
+// invalidPattern returns the pattern for the paths which the server doesn't
+// serve, although the characters are allowed: a package with a `.git`
+// suffix, elements starting with a dot, Windows reserved names and short
+// names, as rejected by checkModulePath.
+func invalidPattern() string {
+	var names []string
+	for name := range reservedNames {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return `^/[^/]*\.git(?:/|$)|/\.|/(?:` + strings.Join(names, "|") + `)(?:[./]|$)|~[0-9]+(?:[./]|$)`
+}
+
+// proxyVars are the references to the parts of the request in the proxy
+// configuration, which are substituted by the proxy for each request
+type proxyVars struct {
+	// pkg is the package name, and sub is the rest of the path, with a
+	// leading slash. subpath and major are as in modulePath.
+	pkg     string
+	sub     string
+	subpath string
+	major   string
+
+	// query is the query string, and addQuery is the query string with a
+	// leading `?`, or empty if the request has no query
+	query    string
+	addQuery string
+}
+
+// proxyResponse is the response of the proxy to a kind of client
+type proxyResponse struct {
+	code     int
+	body     string
+	location string
+	lifetime time.Duration
+}
+
+// proxyRoute is a location in the proxy configuration, which is matched
+// either exactly, or with a regular expression
+type proxyRoute struct {
+	name        string
+	comment     string
+	exact       string
+	pattern     string
+	contentType string
+	vars        proxyVars
+	goGet       proxyResponse
+	browser     proxyResponse
+}
+
+// ProxyConfig writes a configuration for a reverse proxy in the given format,
+// which answers requests without running the server. The configuration is
+// meant to be included in the server block for the base URL.
+//
+// The root and the known packages are served the same go-get responses and
+// browser redirects as the server, and the subpackages are served the page
+// of the package. Other packages are not found, or redirected to the
+// not-found redirect, since their pages would have to include the request,
+// which the proxy can't escape. The paths are only matched if they follow
+// the rules checked by the server, and the paths it rejects are not found.
+// Paths with a version are not matched, and neither are the `.well-known`
+// files.
+//
+// The proxy can't check the redirects against the allowed hosts like the
+// server does, so an error is returned if a redirect template puts any part
+// of the request in the host, e.g., `https://{pkg}.example.com`.
+func (s *Server) ProxyConfig(w io.Writer, format ProxyFormat) error {
+	c := s.config()
+
+	var vars func(name string) proxyVars
+	var write func(w io.Writer, routes []proxyRoute) error
+	switch format {
+	case ProxyNginx:
+		vars = func(string) proxyVars {
+			return proxyVars{"${pkg}", "${sub}", "${subpath}", "${major}", "$args", "$is_args$args"}
+		}
+		write = c.writeNginx
+
+	case ProxyCaddy:
+		vars = func(name string) proxyVars {
+			re := func(group string) string {
+				return "{re." + name + "." + group + "}"
+			}
+			return proxyVars{re("pkg"), re("sub"), re("subpath"), re("major"), "{query}", "{?query}"}
+		}
+		write = c.writeCaddy
+
+	default:
+		return fmt.Errorf("Unknown proxy format %v", format)
+	}
+
+	routes, err := c.proxyRoutes(vars)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if err := write(&buf, routes); err != nil {
+		return err
+	}
+
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+// proxyExpand replaces the placeholders in the redirect template with the
+// references to the request
+func (c *config) proxyExpand(tpl string, v proxyVars) string {
+	path := v.pkg + v.sub
+	importPath := c.base
+	if path != "" {
+		importPath += "/" + path
+	}
+
+	r := strings.NewReplacer(
+		"{base}", c.base,
+		"{pkg}", v.pkg,
+		"{importpath}", importPath,
+		"{path}", path,
+		"{subpath}", v.subpath,
+		"{major}", v.major,
+		"{version}", "",
+		"{atversion}", "",
+		"{query}", v.query,
+	)
+
+	return r.Replace(tpl)
+}
+
+// proxyHost checks that the request can't change the host of the redirect
+// template. The proxy redirects without the host check of the server, so a
+// placeholder in the host, or before it, would allow an open redirect.
+func (c *config) proxyHost(tpl string) error {
+	if !hasPlaceholders(tpl) {
+		return nil
+	}
+
+	target := wildcards.Replace(strings.Replace(tpl, "{base}", c.base, -1))
+	u, err := url.Parse(target)
+	if err == nil && !strings.HasPrefix(target, "*") && !strings.Contains(u.Scheme+u.Host, "*") {
+		return nil
+	}
+	return fmt.Errorf("The redirect %v puts the request in the host, which the proxy can't check", tpl)
+}
+
+// proxyRedirect returns the redirect location for the kind of redirect, in
+// the same way as redirectTarget
+func (c *config) proxyRedirect(kind redirectKind, v proxyVars) (proxyResponse, error) {
+	var tpl, target string
+	switch kind {
+	case redirectRoot:
+		tpl = c.rootRedirect
+		target = c.proxyExpand(tpl, v)
+
+	case redirectNotFound:
+		tpl = c.notFoundRedirect
+		target = c.proxyExpand(tpl, v)
+
+	default:
+		tpl = c.redirect
+		switch {
+		case hasPlaceholders(tpl):
+			target = c.proxyExpand(tpl, v)
+		case tpl == c.repo.root:
+			target = c.repoURL(v.pkg)
+		default:
+			target = tpl + "/" + v.pkg + v.sub
+		}
+	}
+
+	if err := c.proxyHost(tpl); err != nil {
+		return proxyResponse{}, err
+	}
+
+	if c.preserveQuery && !strings.Contains(tpl, "{query}") {
+		if strings.Contains(target, "?") {
+			target += "&" + v.query
+		} else {
+			target += v.addQuery
+		}
+	}
+
+	return proxyResponse{code: c.redirectCodes[kind], location: target, lifetime: c.lifetimes.redirect}, nil
+}
+
+// proxyPage renders the template for the request path as a response
+func (c *config) proxyPage(tpl *template.Template, data interface{}) (proxyResponse, error) {
+	var buf bytes.Buffer
+	if err := tpl.Execute(&buf, data); err != nil {
+		return proxyResponse{}, err
+	}
+
+	return proxyResponse{code: http.StatusOK, body: buf.String(), lifetime: c.lifetimes.meta}, nil
+}
+
+// proxyRoutes builds the routes of the proxy configuration. vars returns the
+// references to the request for the route with the given name.
+func (c *config) proxyRoutes(vars func(name string) proxyVars) ([]proxyRoute, error) {
+	html := "text/html; charset=utf-8"
+	robots := proxyResponse{code: http.StatusOK, body: "User-agent: *\nDisallow:\n", lifetime: -1}
+	notFound := proxyResponse{code: http.StatusNotFound, lifetime: c.lifetimes.notFound}
+
+	routes := []proxyRoute{{
+		exact:       "/robots.txt",
+		contentType: "text/plain; charset=utf-8",
+		goGet:       robots,
+		browser:     robots,
+	}}
+
+	// The root is served the index or redirected to the root redirect,
+	// and the go tool is served the root repository, if there is one.
+	// There are no captures for exact paths, only the query.
+	v := vars("")
+	root := proxyRoute{comment: "The base URL", exact: "/", contentType: html}
+	root.vars = proxyVars{query: v.query, addQuery: v.addQuery}
+	redirect, err := c.proxyRedirect(redirectRoot, root.vars)
+	if err != nil {
+		return nil, err
+	}
+	root.browser = redirect
+	if c.index {
+		page, err := c.proxyPage(indexTemplate, struct {
+			Base     string
+			Packages []IndexEntry
+		}{c.base, c.indexEntries(nil)})
+		if err != nil {
+			return nil, err
+		}
+		root.browser = page
+	}
+
+	root.goGet = redirect
+	if c.rootRepo != "" {
+		page, err := c.proxyPage(c.template, c.templateData("/"))
+		if err != nil {
+			return nil, err
+		}
+		root.goGet = page
+	}
+	routes = append(routes, root)
+
+	// Paths which the server rejects are not found, before they can match
+	// any package
+	routes = append(routes, proxyRoute{
+		name:        "vanity_invalid",
+		comment:     "Invalid paths",
+		pattern:     invalidPattern(),
+		contentType: html,
+		goGet:       notFound,
+		browser:     notFound,
+	})
+
+	// The known packages
+	for i, p := range c.sortedPackages() {
+		r := proxyRoute{name: fmt.Sprintf("vanity_%v", i), contentType: html}
+		r.comment = "Package " + p.Name
+		r.pattern = "^/" + regexp.QuoteMeta(p.Name) + subPattern
+		r.vars = vars(r.name)
+		r.vars.pkg = p.Name
+
+		if r.goGet, err = c.proxyPage(c.template, c.templateData("/"+p.Name)); err != nil {
+			return nil, err
+		}
+
+		if r.browser, err = c.proxyRedirect(redirectPackage, r.vars); err != nil {
+			return nil, err
+		}
+		if c.landing {
+			if r.browser, err = c.proxyPage(landingTemplate, c.landingData("/"+p.Name)); err != nil {
+				return nil, err
+			}
+		}
+		routes = append(routes, r)
+	}
+
+	// Any other package is not found. The pages would have to include the
+	// package from the request, which the proxy can't escape, so they are
+	// not served even if the server doesn't query the remote.
+	other := proxyRoute{name: "vanity_other", comment: "Other packages", contentType: html}
+	other.pattern = "^/(?P<pkg>" + pkgPattern + ")" + subPattern
+	other.vars = vars(other.name)
+	other.goGet = notFound
+	other.browser = notFound
+	if c.notFoundRedirect != "" {
+		if other.browser, err = c.proxyRedirect(redirectNotFound, other.vars); err != nil {
+			return nil, err
+		}
+	}
+	routes = append(routes, other)
+
+	return routes, nil
+}
+
+// cacheControl returns the Cache-Control header for the lifetime, as set by
+// setCacheControl
+func cacheControl(d time.Duration) string {
+	switch {
+	case d > 0:
+		return fmt.Sprintf("public, max-age=%d", int64(d/time.Second))
+	case d == 0:
+		return "no-cache"
+	}
+	return ""
+}
+
+// nginxGoGet matches the query of go-get requests
+const nginxGoGet = `(?:^|&)go-get=1(?:&|$)`
+
+// writeNginx writes the routes as nginx location blocks
+func (c *config) writeNginx(w io.Writer, routes []proxyRoute) error {
+	fmt.Fprintf(w, "# nginx configuration generated by vanity for %v. Include it in the\n", c.base)
+	fmt.Fprintf(w, "# server block for %v.\n", c.base)
+
+	for _, r := range routes {
+		fmt.Fprintln(w)
+		if r.comment != "" {
+			fmt.Fprintf(w, "# %v\n", r.comment)
+		}
+		if r.exact != "" {
+			fmt.Fprintf(w, "location = %v {\n", r.exact)
+		} else {
+			fmt.Fprintf(w, "location ~ %v {\n", nginxQuote(r.pattern))
+		}
+		fmt.Fprintf(w, "\tdefault_type %v;\n", nginxQuote(r.contentType))
+
+		if r.goGet != r.browser {
+			fmt.Fprintf(w, "\tif ($args ~ %v) {\n", nginxQuote(nginxGoGet))
+			if err := writeNginxResponse(w, "\t\t", r.goGet); err != nil {
+				return fmt.Errorf("%v: %v", r.comment, err)
+			}
+			fmt.Fprintf(w, "\t}\n")
+		}
+		if err := writeNginxResponse(w, "\t", r.browser); err != nil {
+			return fmt.Errorf("%v: %v", r.comment, err)
+		}
+		fmt.Fprintf(w, "}\n")
+	}
+
+	return nil
+}
+
+// nginxEscape escapes the text for a single quoted nginx string
+var nginxEscape = strings.NewReplacer(`\`, `\\`, `'`, `\'`, "\n", `\n`, "\t", `\t`)
+
+// nginxQuote returns the text as a double quoted nginx string
+func nginxQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// writeNginxResponse writes the directives for the response
+func writeNginxResponse(w io.Writer, indent string, resp proxyResponse) error {
+	if cc := cacheControl(resp.lifetime); cc != "" {
+		fmt.Fprintf(w, "%vadd_header Cache-Control %v always;\n", indent, nginxQuote(cc))
+	}
+
+	switch {
+	case resp.location != "":
+		fmt.Fprintf(w, "%vreturn %v %v;\n", indent, resp.code, nginxQuote(resp.location))
+
+	case resp.body != "":
+		// The text may contain variables, which can't be escaped
+		if strings.Contains(resp.body, "$") {
+			return fmt.Errorf("The page contains $, which can't be used in nginx")
+		}
+		fmt.Fprintf(w, "%vreturn %v '%v';\n", indent, resp.code, nginxEscape.Replace(resp.body))
+
+	default:
+		fmt.Fprintf(w, "%vreturn %v;\n", indent, resp.code)
+	}
+
+	return nil
+}
+
+// writeCaddy writes the routes as Caddyfile handle blocks
+func (c *config) writeCaddy(w io.Writer, routes []proxyRoute) error {
+	fmt.Fprintf(w, "# Caddyfile configuration generated by vanity for %v. Include it in\n", c.base)
+	fmt.Fprintf(w, "# the site block for %v.\n", c.base)
+
+	for _, r := range routes {
+		fmt.Fprintln(w)
+		if r.comment != "" {
+			fmt.Fprintf(w, "# %v\n", r.comment)
+		}
+		if r.exact != "" {
+			fmt.Fprintf(w, "handle %v {\n", r.exact)
+		} else {
+			fmt.Fprintf(w, "@%v path_regexp %v `%v`\n", r.name, r.name, r.pattern)
+			fmt.Fprintf(w, "handle @%v {\n", r.name)
+		}
+
+		if r.goGet == r.browser {
+			if err := writeCaddyResponse(w, "", r.contentType, r.browser); err != nil {
+				return fmt.Errorf("%v: %v", r.comment, err)
+			}
+		} else {
+			fmt.Fprintf(w, "\t@goget query go-get=1\n")
+			fmt.Fprintf(w, "\t@browser not query go-get=1\n")
+			if err := writeCaddyResponse(w, "@goget ", r.contentType, r.goGet); err != nil {
+				return fmt.Errorf("%v: %v", r.comment, err)
+			}
+			if err := writeCaddyResponse(w, "@browser ", r.contentType, r.browser); err != nil {
+				return fmt.Errorf("%v: %v", r.comment, err)
+			}
+		}
+		fmt.Fprintf(w, "}\n")
+	}
+
+	return nil
+}
+
+// caddyEscape escapes the braces in the text, so that they are not taken as
+// placeholders
+var caddyEscape = strings.NewReplacer("{", `\{`, "}", `\}`)
+
+// writeCaddyResponse writes the directives for the response, using the
+// matcher if it is not empty
+func writeCaddyResponse(w io.Writer, matcher, contentType string, resp proxyResponse) error {
+	if cc := cacheControl(resp.lifetime); cc != "" {
+		fmt.Fprintf(w, "\theader %vCache-Control %q\n", matcher, cc)
+	}
+
+	switch {
+	case resp.location != "":
+		fmt.Fprintf(w, "\tredir %v%v %v\n", matcher, resp.location, resp.code)
+
+	case resp.body != "":
+		if strings.Contains(resp.body, "`") {
+			return fmt.Errorf("The page contains `, which can't be used in a Caddyfile")
+		}
+		fmt.Fprintf(w, "\theader %vContent-Type %q\n", matcher, contentType)
+		fmt.Fprintf(w, "\trespond %v`%v` %v\n", matcher, caddyEscape.Replace(resp.body), resp.code)
+
+	default:
+		fmt.Fprintf(w, "\trespond %v%v\n", matcher, resp.code)
+	}
+
+	return nil
+}
@@ -0,0 +1,223 @@
+// Copyright 2019 Nirenjan Krishnan. All rights reserved.
+
+package vanity
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "Update the golden files in testdata")
+
+func TestProxyConfig(t *testing.T) {
+	basic := func() *Server {
+		s, _ := NewServer("nirenjan.org", "https://github.com/nirenjan/go-", "https://pkg.go.dev/{importpath}")
+		s.Repo().SetProvider("github")
+		s.AddPackage(Package{Name: "semver"})
+		s.AddPackage(Package{Name: "go.uuid", Repo: "https://git.example.com/uuid"})
+		s.NotFoundRedirect("https://nirenjan.org/missing?pkg={pkg}")
+		return s
+	}
+
+	full := func() *Server {
+		s, _ := NewServer("example.com", "https://git.example.com/", "https://docs.example.com")
+		s.Repo().SetType("mercurial")
+		s.RootRepo("https://git.example.com/root")
+		s.QueryRemote(false)
+		s.Index(true)
+		s.LandingPages(true, 5*time.Second)
+		s.CacheLifetimes(time.Minute, 0, -1)
+		s.AddPackage(Package{Name: "quote", Description: "Pithy 'sayings'", Version: "v3.1.0"})
+		return s
+	}
+
+	redirects := func() *Server {
+		s, _ := NewServer("example.com", "https://git.example.com/", "https://docs.example.com/{pkg}/{major}?path={subpath}&{query}")
+		s.RootRedirect("https://example.com/about")
+		s.RedirectCodes(http.StatusMovedPermanently, http.StatusTemporaryRedirect, http.StatusFound)
+		s.PreserveQuery(true)
+		s.AddPackage(Package{Name: "quote"})
+		s.NotFoundRedirect("https://example.com/search")
+		return s
+	}
+
+	// Placeholders for the base, or after the host, are allowed
+	hosts := func() *Server {
+		s, _ := NewServer("example.com", "https://git.example.com/", "https://docs.{base}/{pkg}?q={query}")
+		s.RootRedirect("https://{base}/about")
+		s.AddPackage(Package{Name: "quote"})
+		s.NotFoundRedirect("https://{base}/search?q={path}")
+		return s
+	}
+
+	checks := []struct {
+		name   string
+		server func() *Server
+		format ProxyFormat
+	}{
+		{"basic.nginx", basic, ProxyNginx},
+		{"basic.caddy", basic, ProxyCaddy},
+		{"full.nginx", full, ProxyNginx},
+		{"full.caddy", full, ProxyCaddy},
+		{"redirects.nginx", redirects, ProxyNginx},
+		{"redirects.caddy", redirects, ProxyCaddy},
+		{"hosts.nginx", hosts, ProxyNginx},
+		{"hosts.caddy", hosts, ProxyCaddy},
+	}
+
+	for _, c := range checks {
+		var buf bytes.Buffer
+		if err := c.server().ProxyConfig(&buf, c.format); err != nil {
+			t.Errorf("Unexpected error for %v: %v", c.name, err)
+			continue
+		}
+
+		golden := filepath.Join("testdata", "proxy-"+c.name+".golden")
+		if *update {
+			if err := ioutil.WriteFile(golden, buf.Bytes(), 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+
+		exp, err := ioutil.ReadFile(golden)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf.Bytes(), exp) {
+			t.Errorf("Mismatch in %v, run go test -update to update the golden file, got\n%s", golden, buf.Bytes())
+		}
+	}
+}
+
+func TestProxyPattern(t *testing.T) {
+	re := regexp.MustCompile("^/(?P<pkg>" + pkgPattern + ")" + subPattern)
+	invalid := regexp.MustCompile(invalidPattern())
+
+	checks := []struct {
+		path string
+		pkg  string
+		sub  string
+		subp string
+		maj  string
+		ok   bool
+	}{
+		{"/quote", "quote", "", "", "", true},
+		{"/quote/", "quote", "/", "", "", true},
+		{"/quote/v3", "quote", "/v3", "v3", "v3", true},
+		{"/quote/v3/sub", "quote", "/v3/sub", "v3/sub", "v3", true},
+		{"/quote/v1/sub", "quote", "/v1/sub", "v1/sub", "", true},
+		{"/quote/v10", "quote", "/v10", "v10", "v10", true},
+		{"/quote/v3x", "quote", "/v3x", "v3x", "", true},
+		{"/quote/sub/v3", "quote", "/sub/v3", "sub/v3", "", true},
+		{"/quote@v1.5.2", "", "", "", "", false},
+		{"/quote/v3@v3.1.0", "", "", "", "", false},
+
+		// The proxies match the decoded path, so the captures must not
+		// allow markup, CR and LF, or anything else the server rejects
+		{`/"><script>alert(1)</script>`, "", "", "", "", false},
+		{"/quote/x\r\nSet-Cookie: a=b", "", "", "", "", false},
+		{"/Quote", "", "", "", "", false},
+		{"/quote/Sub", "", "", "", "", false},
+		{"/quöte", "", "", "", "", false},
+		{"/-quote", "", "", "", "", false},
+		{"/quote/..", "", "", "", "", false},
+		{"/quote/a..b", "", "", "", "", false},
+		{"/quote/.hidden", "", "", "", "", false},
+		{"/quote./x", "", "", "", "", false},
+		{"/quote//x", "", "", "", "", false},
+
+		// These are allowed characters, but are not found by the server
+		{"/quote.git", "quote.git", "", "", "", false},
+		{"/quote.git/sub", "quote.git", "/sub", "sub", "", false},
+		{"/con/sub", "con", "/sub", "sub", "", false},
+		{"/quote/aux.txt", "quote", "/aux.txt", "aux.txt", "", false},
+		{"/quote/abcdef~1", "quote", "/abcdef~1", "abcdef~1", "", false},
+		{"/gitlab/x", "gitlab", "/x", "x", "", true},
+		{"/console", "console", "", "", "", true},
+	}
+
+	for _, c := range checks {
+		m := re.FindStringSubmatch(c.path)
+		if invalid.MatchString(c.path) {
+			m = nil
+		}
+		if (m != nil) != c.ok {
+			t.Errorf("Mismatch in match for %q, expected %v", c.path, c.ok)
+			continue
+		}
+		if m == nil {
+			continue
+		}
+
+		got := map[string]string{}
+		for i, name := range re.SubexpNames() {
+			got[name] = m[i]
+		}
+		if got["pkg"] != c.pkg || got["sub"] != c.sub || got["subpath"] != c.subp || got["major"] != c.maj {
+			t.Errorf("Mismatch in captures for %v, expected (%v, %v, %v, %v), got %v",
+				c.path, c.pkg, c.sub, c.subp, c.maj, got)
+		}
+	}
+}
+
+func TestProxyConfigErrors(t *testing.T) {
+	s, _ := NewServer("nirenjan.org", "https://github.com/nirenjan/", "")
+	s.AddPackage(Package{Name: "semver"})
+
+	if _, err := ParseProxyFormat("apache"); err == nil {
+		t.Errorf("Expected error for unknown format")
+	}
+
+	// Pages which can't be written in the config are rejected
+	s.Template(`<meta name="go-import" content="{{ .GoImport }}"><p>$5</p>`)
+	if err := s.ProxyConfig(ioutil.Discard, ProxyNginx); err == nil {
+		t.Errorf("Expected error for $ in nginx page")
+	}
+	if err := s.ProxyConfig(ioutil.Discard, ProxyCaddy); err != nil {
+		t.Errorf("Unexpected error for $ in Caddy page: %v", err)
+	}
+
+	s.Template("<meta name=\"go-import\" content=\"{{ .GoImport }}\"><p>`</p>")
+	if err := s.ProxyConfig(ioutil.Discard, ProxyCaddy); err == nil {
+		t.Errorf("Expected error for ` in Caddy page")
+	}
+
+	// Redirects with the request in the host can't be checked by the proxy
+	for _, c := range []struct {
+		kind   string
+		target string
+	}{
+		{"package", "https://{pkg}.example.com/"},
+		{"package", "https://example.com{path}"},
+		{"package", "{query}"},
+		{"package", "https://{importpath}"},
+		{"root", "https://{query}"},
+		{"not found", "https://search.{pkg}/"},
+	} {
+		redirect := "https://pkg.go.dev/{importpath}"
+		if c.kind == "package" {
+			redirect = c.target
+		}
+		s, _ := NewServer("nirenjan.org", "https://github.com/nirenjan/", redirect)
+		s.AddPackage(Package{Name: "semver"})
+		switch c.kind {
+		case "root":
+			s.RootRedirect(c.target)
+		case "not found":
+			s.NotFoundRedirect(c.target)
+		}
+
+		err := s.ProxyConfig(ioutil.Discard, ProxyNginx)
+		if err == nil || !strings.Contains(err.Error(), "puts the request in the host") {
+			t.Errorf("Mismatch in error for %v redirect %v, got %v", c.kind, c.target, err)
+		}
+	}
+}
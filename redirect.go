@@ -0,0 +1,318 @@
+// Copyright 2019 Nirenjan Krishnan. All rights reserved.
+
+package vanity
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// Redirect targets may contain the following placeholders, which are
+// replaced with the corresponding part of the request. For the request
+// `/quote/v3/sub@v3.1.0?tab=doc` to the base `rsc.io`, these are:
+//
+//	{base}       rsc.io
+//	{pkg}        quote
+//	{importpath} rsc.io/quote/v3/sub
+//	{path}       quote/v3/sub
+//	{subpath}    v3/sub
+//	{major}      v3
+//	{version}    v3.1.0
+//	{atversion}  @v3.1.0
+//	{query}      tab=doc
+//
+// The version placeholders are empty if no version was requested, and the
+// go-get parameter is never included in {query}.
+
+// hasPlaceholders checks if the redirect target is a template
+func hasPlaceholders(target string) bool {
+	return strings.Contains(target, "{") && strings.Contains(target, "}")
+}
+
+// stripGoGet removes the go-get parameter from the raw query string
+func stripGoGet(rawQuery string) string {
+	if rawQuery == "" {
+		return ""
+	}
+
+	var parts []string
+	for _, part := range strings.Split(rawQuery, "&") {
+		key := strings.SplitN(part, "=", 2)[0]
+		if k, err := url.QueryUnescape(key); err == nil && k == "go-get" {
+			continue
+		}
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+
+	return strings.Join(parts, "&")
+}
+
+// expandRedirect replaces the placeholders in the redirect target with the
+// values from the requested module path and raw query.
+func (c *config) expandRedirect(target, module, rawQuery string) string {
+	m := parseModulePath(module)
+
+	importPath := c.base
+	if m.pkg != "" {
+		importPath += "/" + m.path()
+	}
+
+	r := strings.NewReplacer(
+		"{base}", c.base,
+		"{pkg}", m.pkg,
+		"{importpath}", importPath,
+		"{path}", m.path(),
+		"{subpath}", m.subpath,
+		"{major}", m.major,
+		"{version}", m.version,
+		"{atversion}", m.atVersion(),
+		"{query}", stripGoGet(rawQuery),
+	)
+
+	return r.Replace(target)
+}
+
+// redirectKind identifies the type of browser redirect, each of which can
+// use a different status code.
+type redirectKind int
+
+const (
+	// redirectPackage redirects a request for a package
+	redirectPackage redirectKind = iota
+
+	// redirectRoot redirects a request for the root node
+	redirectRoot
+
+	// redirectNotFound redirects a request for a missing package
+	redirectNotFound
+
+	numRedirectKinds
+)
+
+// validRedirectCode checks if the status code can be used for a redirect
+func validRedirectCode(code int) bool {
+	switch code {
+	case http.StatusMovedPermanently, http.StatusFound,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+
+	return false
+}
+
+// RedirectCodes sets the HTTP status codes used for the browser redirects
+// for packages, for the root node, and for missing packages. Each must be
+// one of 301, 302, 307 or 308. The default is 302 for all redirects.
+func (s *Server) RedirectCodes(pkg, root, notFound int) error {
+	for _, code := range []int{pkg, root, notFound} {
+		if !validRedirectCode(code) {
+			return fmt.Errorf("Invalid redirect code %v", code)
+		}
+	}
+
+	return s.update(func(c *config) error {
+		c.redirectCodes = [numRedirectKinds]int{pkg, root, notFound}
+		return nil
+	})
+}
+
+// PreserveQuery controls whether the query parameters of the request are
+// added to the browser redirect location. The go-get parameter is always
+// removed. Redirects which use the {query} placeholder are not modified.
+func (s *Server) PreserveQuery(preserve bool) {
+	s.update(func(c *config) error {
+		c.preserveQuery = preserve
+		return nil
+	})
+}
+
+// appendQuery adds the raw query to the URL, before any fragment
+func appendQuery(target, rawQuery string) string {
+	if rawQuery == "" {
+		return target
+	}
+
+	fragment := ""
+	if i := strings.Index(target, "#"); i >= 0 {
+		target, fragment = target[:i], target[i:]
+	}
+
+	switch {
+	case !strings.Contains(target, "?"):
+		target += "?"
+	case !strings.HasSuffix(target, "?") && !strings.HasSuffix(target, "&"):
+		target += "&"
+	}
+
+	return target + rawQuery + fragment
+}
+
+// redirectTarget returns the location for the given kind of redirect
+func (c *config) redirectTarget(kind redirectKind, module, rawQuery string) string {
+	var tpl, target string
+
+	switch kind {
+	case redirectRoot:
+		tpl = c.rootRedirect
+		target = c.expandRedirect(tpl, "/", rawQuery)
+
+	case redirectNotFound:
+		tpl = c.notFoundRedirect
+		target = c.expandRedirect(tpl, module, rawQuery)
+
+	default:
+		tpl = c.redirect
+		target = c.getRedirect(module, rawQuery)
+	}
+
+	if c.preserveQuery && !strings.Contains(tpl, "{query}") {
+		target = appendQuery(target, stripGoGet(rawQuery))
+	}
+
+	return target
+}
+
+// wildcards replaces the request placeholders with `*`, so that a redirect
+// template can be used as a host pattern.
+var wildcards = strings.NewReplacer(
+	"{pkg}", "*",
+	"{importpath}", "*",
+	"{path}", "*",
+	"{subpath}", "*",
+	"{major}", "*",
+	"{version}", "*",
+	"{atversion}", "*",
+	"{query}", "*",
+)
+
+// hostPattern returns the host of the URL as a lowercase pattern, with the
+// placeholders replaced by wildcards. It returns an empty string if the URL
+// has no host, if the host is made up only of placeholders, which would
+// match any host, or if it is incomplete without them, e.g., `example.{pkg}`.
+func (c *config) hostPattern(target string) string {
+	if hasPlaceholders(target) {
+		target = wildcards.Replace(strings.Replace(target, "{base}", c.base, -1))
+	}
+
+	u, err := url.Parse(target)
+	if err != nil || u.Host == "" {
+		return ""
+	}
+
+	// Wildcards may only stand for subdomains of a fixed domain, e.g.,
+	// `*.example.com`. Otherwise, the host must match exactly, since a
+	// placeholder right after the host, as in `https://example.com{path}`,
+	// would allow `example.com.evil.com`.
+	host := strings.ToLower(u.Host)
+	if i := strings.LastIndex(host, "*"); i >= 0 {
+		suffix := host[i+1:]
+		if !strings.HasPrefix(suffix, ".") || strings.Count(suffix, ".") < 2 {
+			host = strings.Replace(host, "*", "", -1)
+		}
+	}
+
+	if strings.Trim(host, "*.") == "" || strings.HasPrefix(host, ".") || strings.HasSuffix(host, ".") {
+		return ""
+	}
+	return host
+}
+
+// redirectHosts returns the host patterns that browsers may be redirected
+// to. These are derived from the base, the repository root, the redirects
+// and the known packages.
+func (c *config) redirectHosts() []string {
+	targets := []string{
+		"https://" + c.base,
+		c.repo.root,
+		c.rootRepo,
+		c.redirect,
+		c.rootRedirect,
+		c.notFoundRedirect,
+	}
+	for _, p := range c.packages {
+		targets = append(targets, p.Repo, p.Docs)
+	}
+
+	var hosts []string
+	for _, t := range targets {
+		if h := c.hostPattern(t); h != "" {
+			hosts = append(hosts, h)
+		}
+	}
+
+	return hosts
+}
+
+// checkRedirect verifies that the redirect target is either relative to the
+// server, or points to one of the allowed hosts. Targets with credentials or
+// a scheme other than HTTP(S) are always rejected.
+func (c *config) checkRedirect(target string) error {
+	if strings.Contains(target, "\\") {
+		return fmt.Errorf("Redirect contains a backslash")
+	}
+
+	u, err := url.Parse(target)
+	if err != nil {
+		return err
+	}
+
+	if u.User != nil {
+		return fmt.Errorf("Redirect contains user information")
+	}
+
+	if u.Scheme == "" && u.Host == "" {
+		// Relative to the server, and http.Redirect cleans the path
+		return nil
+	}
+
+	if u.Scheme != "" && u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("Redirect has invalid scheme %#v", u.Scheme)
+	}
+
+	host := strings.ToLower(u.Host)
+	for _, pattern := range c.redirectHosts() {
+		if ok, _ := path.Match(pattern, host); ok {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("Redirect host %v is not allowed", u.Host)
+}
+
+// allowedRedirect returns the target if it passes the redirect check, and
+// an empty string otherwise, so that the pages never link to a target which
+// would be rejected as a redirect.
+func (c *config) allowedRedirect(target string) string {
+	if err := c.checkRedirect(target); err != nil {
+		log.Printf("Omitted link to %v: %v", target, err)
+		return ""
+	}
+	return target
+}
+
+// sendRedirect sends the redirect response for the module with the status
+// code and cache lifetime for the kind of redirect. Targets which fail the
+// redirect check are logged and rejected with a 400 response.
+func (s *Server) sendRedirect(w http.ResponseWriter, r *http.Request, kind redirectKind, module string) {
+	c := s.requestConfig(r)
+	target := c.redirectTarget(kind, module, r.URL.RawQuery)
+	if err := c.checkRedirect(target); err != nil {
+		log.Printf("Rejected redirect for %v to %v: %v", r.URL.RequestURI(), target, err)
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	if kind == redirectNotFound {
+		setCacheControl(w, c.lifetimes.notFound)
+	} else {
+		setCacheControl(w, c.lifetimes.redirect)
+	}
+
+	http.Redirect(w, r, absoluteURL(r, target), c.redirectCodes[kind])
+}
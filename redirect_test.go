@@ -0,0 +1,278 @@
+// Copyright 2019 Nirenjan Krishnan. All rights reserved.
+
+package vanity
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStripGoGet(t *testing.T) {
+	checks := []struct {
+		in  string
+		out string
+	}{
+		{"", ""},
+		{"go-get=1", ""},
+		{"tab=doc&go-get=1", "tab=doc"},
+		{"go-get=1&tab=doc&utm_source=x", "tab=doc&utm_source=x"},
+		{"go%2Dget=1&a=b", "a=b"},
+		{"a=b&&c", "a=b&c"},
+	}
+
+	for _, c := range checks {
+		if res := stripGoGet(c.in); res != c.out {
+			t.Errorf("Mismatch in stripGoGet(%#v), expected %#v, got %#v", c.in, c.out, res)
+		}
+	}
+}
+
+func TestExpandRedirect(t *testing.T) {
+	s, _ := NewServer("rsc.io", "https://github.com/rsc/", "")
+
+	checks := []struct {
+		target string
+		module string
+		query  string
+		out    string
+	}{
+		{"https://pkg.go.dev/{importpath}", "/quote/v3", "go-get=1", "https://pkg.go.dev/rsc.io/quote/v3"},
+		{"https://docs.example.com/{pkg}/latest/", "/quote/v3", "", "https://docs.example.com/quote/latest/"},
+		{"https://docs.example.com/{pkg}#{subpath}", "/quote/v3/sub", "", "https://docs.example.com/quote#v3/sub"},
+		{"https://example.com/{base}/{path}?{query}", "/quote", "tab=doc&go-get=0", "https://example.com/rsc.io/quote?tab=doc"},
+		{"https://pkg.go.dev/{importpath}", "/", "", "https://pkg.go.dev/rsc.io"},
+		{"https://example.com/", "/quote", "", "https://example.com/"},
+	}
+
+	for _, c := range checks {
+		if res := s.config().expandRedirect(c.target, c.module, c.query); res != c.out {
+			t.Errorf("Mismatch in expandRedirect(%v, %v, %v), expected %#v, got %#v",
+				c.target, c.module, c.query, c.out, res)
+		}
+	}
+}
+
+func TestRedirectTemplates(t *testing.T) {
+	mock := mockServer(t)
+	defer mock.Close()
+
+	s, _ := NewServer("rsc.io", mockAddr(mock), "https://pkg.go.dev/{importpath}?{query}")
+	s.client = mock.Client()
+	s.RootRedirect("https://pkg.go.dev/search?q={base}")
+	s.NotFoundRedirect("https://example.com/missing/{pkg}/")
+
+	checks := []struct {
+		path     string
+		code     int
+		location string
+	}{
+		{"/valid/sub?tab=doc", http.StatusFound, "https://pkg.go.dev/rsc.io/valid/sub?tab=doc"},
+		{"/", http.StatusFound, "https://pkg.go.dev/search?q=rsc.io"},
+		{"/invalid/sub", http.StatusFound, "https://example.com/missing/invalid/"},
+		{"/invalid?go-get=1", http.StatusNotFound, ""},
+	}
+
+	for _, c := range checks {
+		rr := httptest.NewRecorder()
+		s.handleGeneric(rr, httptest.NewRequest("GET", c.path, nil))
+
+		if rr.Code != c.code || rr.Header().Get("Location") != c.location {
+			t.Errorf("Mismatch for %v, expected (%v, %#v), got (%v, %#v)", c.path,
+				c.code, c.location, rr.Code, rr.Header().Get("Location"))
+		}
+	}
+}
+
+func TestAppendQuery(t *testing.T) {
+	checks := []struct {
+		target string
+		query  string
+		out    string
+	}{
+		{"https://example.com/a", "", "https://example.com/a"},
+		{"https://example.com/a", "x=1", "https://example.com/a?x=1"},
+		{"https://example.com/a?y=2", "x=1", "https://example.com/a?y=2&x=1"},
+		{"https://example.com/a?", "x=1", "https://example.com/a?x=1"},
+		{"https://example.com/a#frag", "x=1", "https://example.com/a?x=1#frag"},
+	}
+
+	for _, c := range checks {
+		if res := appendQuery(c.target, c.query); res != c.out {
+			t.Errorf("Mismatch in appendQuery(%v, %v), expected %#v, got %#v", c.target, c.query, c.out, res)
+		}
+	}
+}
+
+func TestRedirectCodes(t *testing.T) {
+	mock := mockServer(t)
+	defer mock.Close()
+
+	s, _ := NewServer("rsc.io", mockAddr(mock), "https://pkg.go.dev/rsc.io")
+	s.client = mock.Client()
+	s.RootRedirect("https://github.com/rsc?tab=repositories")
+	s.NotFoundRedirect("https://pkg.go.dev/search?q={pkg}&{query}")
+
+	for _, invalid := range [][3]int{{200, 302, 302}, {302, 304, 302}, {302, 302, 404}} {
+		if err := s.RedirectCodes(invalid[0], invalid[1], invalid[2]); err == nil {
+			t.Errorf("Expected error for codes %v, got nil", invalid)
+		}
+	}
+
+	if err := s.RedirectCodes(http.StatusMovedPermanently, http.StatusPermanentRedirect, http.StatusTemporaryRedirect); err != nil {
+		t.Fatalf("Expected nil, got error %v", err)
+	}
+	s.PreserveQuery(true)
+
+	checks := []struct {
+		path     string
+		code     int
+		location string
+	}{
+		{"/valid/sub?utm_source=x&go-get=0", http.StatusMovedPermanently, "https://pkg.go.dev/rsc.io/valid/sub?utm_source=x"},
+		{"/valid", http.StatusMovedPermanently, "https://pkg.go.dev/rsc.io/valid"},
+		{"/?utm_source=x", http.StatusPermanentRedirect, "https://github.com/rsc?tab=repositories&utm_source=x"},
+		// Templates with {query} are not modified
+		{"/invalid?utm_source=x", http.StatusTemporaryRedirect, "https://pkg.go.dev/search?q=invalid&utm_source=x"},
+	}
+
+	for _, c := range checks {
+		rr := httptest.NewRecorder()
+		s.handleGeneric(rr, httptest.NewRequest("GET", c.path, nil))
+
+		if rr.Code != c.code || rr.Header().Get("Location") != c.location {
+			t.Errorf("Mismatch for %v, expected (%v, %#v), got (%v, %#v)", c.path,
+				c.code, c.location, rr.Code, rr.Header().Get("Location"))
+		}
+	}
+
+	// Queries are dropped by default
+	s.PreserveQuery(false)
+	rr := httptest.NewRecorder()
+	s.handleGeneric(rr, httptest.NewRequest("GET", "/valid?utm_source=x", nil))
+	if loc := rr.Header().Get("Location"); loc != "https://pkg.go.dev/rsc.io/valid" {
+		t.Errorf("Unexpected redirect %#v", loc)
+	}
+}
+
+func TestCheckRedirect(t *testing.T) {
+	s, _ := NewServer("nirenjan.org", "https://github.com/nirenjan/go-", "https://{pkg}.docs.example.com/{path}")
+	s.RootRedirect("/index.html")
+	s.AddPackage(Package{Name: "vanity", Repo: "https://git.example.com/vanity", Docs: "http://godoc.example.com:8080/vanity"})
+
+	checks := []struct {
+		target string
+		ok     bool
+	}{
+		{"/index.html", true},
+		{"docs", true},
+		{"https://nirenjan.org/semver", true},
+		{"https://github.com/nirenjan/go-semver", true},
+		{"https://GitHub.com/nirenjan/go-semver", true},
+		{"//github.com/nirenjan/go-semver", true},
+		{"https://semver.docs.example.com/semver", true},
+		{"https://git.example.com/vanity", true},
+		{"http://godoc.example.com:8080/vanity", true},
+		{"http://godoc.example.com/vanity", false},
+		{"https://evil.com/", false},
+		{"//evil.com/", false},
+		{"/\\evil.com/", false},
+		{"https://github.com@evil.com/", false},
+		{"https://evil.com/github.com", false},
+		{"https://docs.example.com/", false},
+		{"javascript:alert(1)", false},
+		{"ftp://github.com/", false},
+	}
+
+	for _, c := range checks {
+		err := s.config().checkRedirect(c.target)
+		if (err == nil) != c.ok {
+			t.Errorf("Mismatch in checkRedirect(%v), expected success %v, got %v", c.target, c.ok, err)
+		}
+	}
+}
+
+func TestRejectedRedirect(t *testing.T) {
+	s, _ := NewServer("nirenjan.org", "https://github.com/nirenjan/go-", "https://{path}")
+	s.QueryRemote(false)
+
+	checks := []struct {
+		path     string
+		code     int
+		location string
+	}{
+		{"/evil.com", http.StatusBadRequest, ""},
+		{"/semver@evil.com", http.StatusBadRequest, ""},
+	}
+
+	for _, c := range checks {
+		rr := httptest.NewRecorder()
+		s.handleGeneric(rr, httptest.NewRequest("GET", c.path, nil))
+
+		if rr.Code != c.code || rr.Header().Get("Location") != c.location {
+			t.Errorf("Mismatch for %v, expected (%v, %#v), got (%v, %#v)", c.path,
+				c.code, c.location, rr.Code, rr.Header().Get("Location"))
+		}
+	}
+
+	// The pages don't refresh to or link to the rejected target
+	pages := []struct {
+		path    string
+		landing bool
+		content string
+	}{
+		{"/evil.com?go-get=1", false, `content="nirenjan.org/evil.com git https://github.com/nirenjan/go-evil.com"`},
+		{"/evil.com", true, "<pre>go get nirenjan.org/evil.com</pre>"},
+		{"/evil.com?go-get=1", true, `content="nirenjan.org/evil.com git https://github.com/nirenjan/go-evil.com"`},
+	}
+
+	for _, c := range pages {
+		s.LandingPages(c.landing, time.Second)
+		rr := httptest.NewRecorder()
+		s.handleGeneric(rr, httptest.NewRequest("GET", c.path, nil))
+
+		body := rr.Body.String()
+		if rr.Code != http.StatusOK || !strings.Contains(body, c.content) {
+			t.Errorf("Mismatch for %v with landing %v, expected 200 with %v, got %v:\n%v",
+				c.path, c.landing, c.content, rr.Code, body)
+		}
+		if strings.Contains(body, "refresh") || strings.Contains(body, "https://evil.com") {
+			t.Errorf("Unexpected redirect for %v with landing %v:\n%v", c.path, c.landing, body)
+		}
+	}
+}
+
+func TestHostPattern(t *testing.T) {
+	s, _ := NewServer("nirenjan.org", "https://github.com/nirenjan/", "")
+	c := s.config()
+
+	checks := []struct {
+		target  string
+		pattern string
+	}{
+		{"https://pkg.go.dev/{importpath}", "pkg.go.dev"},
+		{"https://{pkg}.docs.example.com/", "*.docs.example.com"},
+		{"https://docs-{pkg}.example.com/", "docs-*.example.com"},
+		{"https://{base}/{path}", "nirenjan.org"},
+		{"https://example.com{path}", "example.com"},
+		{"https://example.{pkg}/", ""},
+		{"https://{pkg}.com/", ""},
+		{"https://{path}", ""},
+		{"/docs/{pkg}", ""},
+	}
+
+	for _, chk := range checks {
+		if p := c.hostPattern(chk.target); p != chk.pattern {
+			t.Errorf("Mismatch in hostPattern(%v), expected %#v, got %#v", chk.target, chk.pattern, p)
+		}
+	}
+
+	s, _ = NewServer("nirenjan.org", "https://github.com/nirenjan/", "https://example.com{path}")
+	for _, target := range []string{"https://example.com.evil.com/quote", "https://example.comquote"} {
+		if err := s.config().checkRedirect(target); err == nil {
+			t.Errorf("Expected error for %v", target)
+		}
+	}
+}
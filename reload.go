@@ -0,0 +1,28 @@
+// Copyright 2019 Nirenjan Krishnan. All rights reserved.
+
+package vanity
+
+// Reload replaces the configuration of the server with that of the given
+// server, which is typically created with NewServer and configured with the
+// new settings, but never started. This can be used on a running server.
+// Requests in progress complete with the old configuration, and connections
+// are not interrupted.
+//
+// The base, repository, redirects, packages, index and landing pages,
+// template, cache settings, remote queries, web root and trusted proxies are
+// replaced. The listeners, limits, access log, and the paths of the metrics,
+// health and API endpoints only take effect when the server is started, and
+// are not changed. The upstream cache is flushed, since the repositories may
+// have changed.
+func (s *Server) Reload(from *Server) {
+	next := from.config().clone()
+
+	s.update(func(c *config) error {
+		next.accessLog = c.accessLog
+		*c = *next
+		return nil
+	})
+
+	_, ttl := from.cache.stats()
+	s.cache.setTTL(ttl)
+}
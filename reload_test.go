@@ -0,0 +1,84 @@
+// Copyright 2019 Nirenjan Krishnan. All rights reserved.
+
+package vanity
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReload(t *testing.T) {
+	s, _ := NewServer("nirenjan.org", "https://github.com/nirenjan/go-", "")
+	s.QueryRemote(false)
+	s.AccessLog(nil)
+	s.CacheTTL(time.Minute)
+
+	handler := http.HandlerFunc(s.getHandler("/", s.handleGeneric))
+
+	from, _ := NewServer("nirenjan.org", "https://git.example.com/", "https://pkg.go.dev/{importpath}")
+	from.QueryRemote(false)
+	from.RootRedirect("https://example.com/")
+	from.AddPackage(Package{Name: "semver", Repo: "https://git.example.com/go-semver"})
+	from.CacheTTL(time.Hour)
+	if err := from.Template(`<meta name="go-import" content="{{ .GoImport }}">reloaded`); err != nil {
+		t.Fatal(err)
+	}
+
+	// Requests in flight during the reload must see either configuration
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				rr := httptest.NewRecorder()
+				handler.ServeHTTP(rr, httptest.NewRequest("GET", "/semver?go-get=1", nil))
+				if rr.Code != http.StatusOK {
+					t.Errorf("Unexpected status %v during reload", rr.Code)
+					return
+				}
+			}
+		}()
+	}
+	s.Reload(from)
+	wg.Wait()
+
+	checks := []struct {
+		path     string
+		code     int
+		location string
+		body     string
+	}{
+		{"/semver?go-get=1", http.StatusOK, "", "nirenjan.org/semver git https://git.example.com/go-semver\">reloaded"},
+		{"/vanity?go-get=1", http.StatusOK, "", "nirenjan.org/vanity git https://git.example.com/vanity\">reloaded"},
+		{"/semver/sub", http.StatusFound, "https://pkg.go.dev/nirenjan.org/semver/sub", ""},
+		{"/", http.StatusFound, "https://example.com/", ""},
+	}
+
+	for _, c := range checks {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", c.path, nil))
+
+		if rr.Code != c.code || rr.Header().Get("Location") != c.location {
+			t.Errorf("Mismatch for %v, expected (%v, %#v), got (%v, %#v)", c.path,
+				c.code, c.location, rr.Code, rr.Header().Get("Location"))
+		}
+		if !strings.Contains(rr.Body.String(), c.body) {
+			t.Errorf("Missing %v in response for %v:\n%v", c.body, c.path, rr.Body.String())
+		}
+	}
+
+	if _, ttl := s.cache.stats(); ttl != time.Hour {
+		t.Errorf("Cache TTL not reloaded, got %v", ttl)
+	}
+
+	// Changes to the source after the reload don't affect the server
+	from.AddPackage(Package{Name: "quote"})
+	if len(s.Packages()) != 1 {
+		t.Errorf("Unexpected packages after reload %#v", s.Packages())
+	}
+}
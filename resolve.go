@@ -0,0 +1,190 @@
+// Copyright 2019 Nirenjan Krishnan. All rights reserved.
+
+package vanity
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Resolution describes how the server answers requests for an import path.
+// It is built using the same logic that is used to serve go-get requests.
+type Resolution struct {
+	// ImportPath is the requested import path, including the base
+	ImportPath string `json:"import_path"`
+
+	// ImportPrefix is the import path of the repository root, which is
+	// the first field of the go-import meta tag.
+	ImportPrefix string `json:"import_prefix"`
+
+	// VcsType and RepoURL are the VCS and repository in the go-import
+	// meta tag
+	VcsType string `json:"vcs"`
+	RepoURL string `json:"repo"`
+
+	// GoImport and GoSource are the contents of the meta tags. GoSource
+	// is empty if no source templates are configured.
+	GoImport string `json:"go_import"`
+	GoSource string `json:"go_source,omitempty"`
+
+	// DirTemplate and FileTemplate are the go-source URL templates
+	DirTemplate  string `json:"dir_template,omitempty"`
+	FileTemplate string `json:"file_template,omitempty"`
+
+	// Browser is how browsers are answered, which is one of `redirect`,
+	// `landing`, `index`, `not_found` or `rejected`. Redirect is the
+	// location where browsers are redirected to, and is only set for
+	// `redirect`. Redirects which fail the allowed hosts check are
+	// `rejected`, and the server responds to them with a 400.
+	Browser  string `json:"browser"`
+	Redirect string `json:"redirect,omitempty"`
+
+	// Checked is set if the existence of the repository was verified
+	// with the upstream. If it is not set, the repository is assumed to
+	// exist.
+	Checked bool `json:"checked"`
+
+	// Exists is set if the repository exists, and Status is the status
+	// code returned by the upstream.
+	Exists bool `json:"exists"`
+	Status int  `json:"upstream_status,omitempty"`
+
+	// Cached is set if the result was found in the cache, and CacheAge
+	// is the age of the cached result in seconds.
+	Cached   bool    `json:"cached"`
+	CacheAge float64 `json:"cache_age,omitempty"`
+}
+
+// modulePathFor converts the import path into a request path for the
+// server. The import path may include the base URL, or be relative to it.
+// Paths whose first element looks like a host name are rejected, unless
+// they have a leading slash.
+func (c *config) modulePathFor(importPath string) (string, error) {
+	p := strings.TrimSpace(importPath)
+	if strings.HasPrefix(p, "https://") || strings.HasPrefix(p, "http://") {
+		p = p[strings.Index(p, "://")+3:]
+	}
+
+	if p == c.base {
+		p = ""
+	} else if strings.HasPrefix(p, c.base+"/") {
+		p = strings.TrimPrefix(p, c.base+"/")
+	} else if !strings.HasPrefix(p, "/") && strings.Contains(repoBase(p), ".") {
+		return "", fmt.Errorf("Import path %v is not under %v", importPath, c.base)
+	}
+
+	p = strings.Trim(p, "/")
+	if p == "" {
+		if c.rootRepo != "" {
+			return "/", nil
+		}
+		return "", fmt.Errorf("Import path %v has no package", importPath)
+	}
+
+	if err := checkModulePath("/" + p); err != nil {
+		return "", fmt.Errorf("Invalid import path %v: %v", importPath, err)
+	}
+	return "/" + p, nil
+}
+
+// Resolve returns how the server would respond to a go-get request for the
+// import path. If the server is configured to query the remote, this checks
+// whether the repository exists, using the cached result if available. The
+// browser response is decided in the same way as for requests without
+// go-get=1 in the query.
+func (s *Server) Resolve(importPath string) (*Resolution, error) {
+	c := s.config()
+	module, err := c.modulePathFor(importPath)
+	if err != nil {
+		return nil, err
+	}
+
+	data := c.templateData(module)
+	res := &Resolution{
+		ImportPath:   strings.TrimSuffix(c.base+"/"+data.Request, "/"),
+		ImportPrefix: data.ImportPath,
+		VcsType:      data.VcsType,
+		RepoURL:      data.RepoURL,
+		GoImport:     data.GoImport,
+		GoSource:     data.GoSource,
+		DirTemplate:  data.Dir,
+		FileTemplate: data.File,
+		Exists:       true,
+	}
+
+	if c.queryRemote {
+		e, cached, _ := s.lookupUpstream(c, module)
+		res.Checked = true
+		res.Exists = e.exists
+		res.Status = e.code
+		res.Cached = cached
+		if cached {
+			res.CacheAge = time.Since(e.checked).Seconds()
+		}
+	}
+
+	action, kind := c.browserAction(module, res.Exists)
+	res.Browser = action.String()
+	if action == browserRedirect {
+		target := c.redirectTarget(kind, module, "")
+		if err := c.checkRedirect(target); err != nil {
+			res.Browser = "rejected"
+		} else {
+			res.Redirect = target
+		}
+	}
+
+	return res, nil
+}
+
+// API enables the JSON API under the given prefix. The API currently has
+// a single endpoint, prefix/resolve?path=<import path>, which returns the
+// Resolution for the import path. An empty prefix disables the API. The
+// endpoint is only added when the server is started.
+func (s *Server) API(prefix string) {
+	if prefix != "" && prefix[0] != '/' {
+		prefix = "/" + prefix
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.apiPath = strings.TrimSuffix(prefix, "/")
+}
+
+// writeJSON sends the value as a JSON response with the given status code
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+// jsonError sends the error message as a JSON response
+func jsonError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, struct {
+		Error string `json:"error"`
+	}{err.Error()})
+}
+
+// handleResolve serves the resolve API endpoint
+func (s *Server) handleResolve(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		jsonError(w, http.StatusBadRequest, fmt.Errorf("Missing path parameter"))
+		return
+	}
+
+	res, err := s.Resolve(path)
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, res)
+}
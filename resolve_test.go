@@ -0,0 +1,177 @@
+// Copyright 2019 Nirenjan Krishnan. All rights reserved.
+
+package vanity
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestModulePathFor(t *testing.T) {
+	s, _ := NewServer("nirenjan.org", "https://github.com/nirenjan/", "")
+
+	checks := []struct {
+		in  string
+		out string
+		ok  bool
+	}{
+		{"nirenjan.org/semver", "/semver", true},
+		{"nirenjan.org/semver/core/", "/semver/core", true},
+		{"https://nirenjan.org/semver", "/semver", true},
+		{"semver/core", "/semver/core", true},
+		{"/semver", "/semver", true},
+		{"/go.uuid", "/go.uuid", true},
+		{"github.com/nirenjan/semver", "", false},
+		{"nirenjan.org", "", false},
+		{"nirenjan.org/", "", false},
+	}
+
+	for _, c := range checks {
+		out, err := s.config().modulePathFor(c.in)
+		if (err == nil) != c.ok || out != c.out {
+			t.Errorf("Mismatch in modulePathFor(%v), expected (%#v, %v), got (%#v, %v)",
+				c.in, c.out, c.ok, out, err)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	mock := mockServer(t)
+	defer mock.Close()
+
+	root := mockAddr(mock)
+	s, _ := NewServer("nirenjan.org", root, "https://pkg.go.dev/nirenjan.org")
+	s.client = mock.Client()
+	s.Repo().SetProvider("github")
+	s.CacheTTL(time.Minute)
+
+	res, err := s.Resolve("nirenjan.org/valid/sub")
+	if err != nil {
+		t.Fatalf("Expected nil, got error %v", err)
+	}
+
+	expected := Resolution{
+		ImportPath:   "nirenjan.org/valid/sub",
+		ImportPrefix: "nirenjan.org/valid",
+		VcsType:      "git",
+		RepoURL:      root + "valid",
+		GoImport:     "nirenjan.org/valid git " + root + "valid",
+		GoSource: "nirenjan.org/valid " + root + "valid " + root + "valid/tree/master{/dir} " +
+			root + "valid/blob/master{/dir}/{file}#L{line}",
+		DirTemplate:  "tree/master{/dir}",
+		FileTemplate: "blob/master{/dir}/{file}#L{line}",
+		Browser:      "redirect",
+		Redirect:     "https://pkg.go.dev/nirenjan.org/valid/sub",
+		Checked:      true,
+		Exists:       true,
+		Status:       http.StatusOK,
+	}
+	if *res != expected {
+		t.Errorf("Mismatch in resolution, expected\n%#v\ngot\n%#v", expected, *res)
+	}
+
+	// The second lookup is cached
+	res, _ = s.Resolve("valid")
+	if !res.Cached || !res.Exists {
+		t.Errorf("Expected cached resolution, got %#v", res)
+	}
+
+	res, _ = s.Resolve("invalid")
+	if res.Exists || res.Status != http.StatusNotFound || res.Cached {
+		t.Errorf("Unexpected resolution for missing package %#v", res)
+	}
+}
+
+func TestResolveBrowser(t *testing.T) {
+	mock := mockServer(t)
+	defer mock.Close()
+
+	root := mockAddr(mock)
+	checks := []struct {
+		name     string
+		setup    func(s *Server)
+		path     string
+		browser  string
+		redirect string
+	}{
+		{"package", func(s *Server) {}, "valid/sub", "redirect", "https://pkg.go.dev/nirenjan.org/valid/sub"},
+		{"root", func(s *Server) {}, "/", "redirect", root},
+		{"index", func(s *Server) { s.Index(true) }, "/", "index", ""},
+		{"landing", func(s *Server) { s.LandingPages(true, 0) }, "valid", "landing", ""},
+		{"missing", func(s *Server) { s.LandingPages(true, 0) }, "invalid", "not_found", ""},
+		{"not found redirect", func(s *Server) { s.NotFoundRedirect("/missing/{pkg}") }, "invalid", "redirect", "/missing/invalid"},
+		{"disallowed", func(s *Server) { s.NotFoundRedirect("https://{pkg}") }, "/evil.com", "rejected", ""},
+	}
+
+	for _, c := range checks {
+		s, _ := NewServer("nirenjan.org", root, "https://pkg.go.dev/nirenjan.org")
+		s.client = mock.Client()
+		s.RootRepo(root + "valid")
+		c.setup(s)
+
+		res, err := s.Resolve(c.path)
+		if err != nil {
+			t.Errorf("Unexpected error for %v: %v", c.name, err)
+			continue
+		}
+		if res.Browser != c.browser || res.Redirect != c.redirect {
+			t.Errorf("Mismatch in browser response for %v, expected (%v, %#v), got (%v, %#v)",
+				c.name, c.browser, c.redirect, res.Browser, res.Redirect)
+		}
+
+		// The handler responds in the same way
+		rr := httptest.NewRecorder()
+		s.handleGeneric(rr, httptest.NewRequest("GET", "/"+strings.TrimPrefix(c.path, "/"), nil))
+		code := map[string]int{
+			"redirect":  http.StatusFound,
+			"landing":   http.StatusOK,
+			"index":     http.StatusOK,
+			"not_found": http.StatusNotFound,
+			"rejected":  http.StatusBadRequest,
+		}[c.browser]
+		if rr.Code != code || rr.Header().Get("Location") != c.redirect {
+			t.Errorf("Mismatch in handler response for %v, expected (%v, %#v), got (%v, %#v)",
+				c.name, code, c.redirect, rr.Code, rr.Header().Get("Location"))
+		}
+	}
+}
+
+func TestHandleResolve(t *testing.T) {
+	s, _ := NewServer("nirenjan.org", "https://github.com/nirenjan/", "")
+	s.QueryRemote(false)
+	s.API("/_api/")
+
+	if s.apiPath != "/_api" {
+		t.Errorf("Unexpected API path %#v", s.apiPath)
+	}
+
+	checks := []struct {
+		query string
+		code  int
+		field string
+		value string
+	}{
+		{"?path=nirenjan.org/semver", http.StatusOK, "repo", "https://github.com/nirenjan/semver"},
+		{"", http.StatusBadRequest, "error", "Missing path parameter"},
+		{"?path=example.com/semver", http.StatusBadRequest, "error", "Import path example.com/semver is not under nirenjan.org"},
+	}
+
+	for _, c := range checks {
+		rr := httptest.NewRecorder()
+		s.handleResolve(rr, httptest.NewRequest("GET", "/_api/resolve"+c.query, nil))
+
+		var out map[string]interface{}
+		if err := json.Unmarshal(rr.Body.Bytes(), &out); err != nil {
+			t.Fatalf("Invalid JSON %#v: %v", rr.Body.String(), err)
+		}
+
+		if rr.Code != c.code || out[c.field] != c.value {
+			t.Errorf("Mismatch for %#v, expected (%v, %v=%#v), got (%v, %v)",
+				c.query, c.code, c.field, c.value, rr.Code, out)
+		}
+	}
+}
@@ -18,6 +18,11 @@ import (
 // browsers. If redirect is empty, then the value of root is used as the
 // redirect value. This returns a *Server which is used to configure and serve
 // the repository.
+//
+// The redirect is normally a prefix to which the request path is appended,
+// but it may instead contain the placeholders {base}, {pkg}, {importpath},
+// {path}, {subpath} and {query}, which are replaced with the corresponding
+// parts of the request, e.g., `https://pkg.go.dev/{importpath}`.
 func NewServer(base, root, redirect string) (*Server, error) {
 	// Trim any trailing slashes, this will simplify the template
 	// handling later
@@ -26,7 +31,9 @@ func NewServer(base, root, redirect string) (*Server, error) {
 		// Trim the trailing multiple slashes and add a single /
 		root = strings.TrimSuffix(root, "/") + "/"
 	}
-	redirect = strings.TrimSuffix(redirect, "/")
+	if !hasPlaceholders(redirect) {
+		redirect = strings.TrimSuffix(redirect, "/")
+	}
 
 	if base == "" {
 		return nil, fmt.Errorf("Missing or invalid base value")
@@ -35,61 +42,156 @@ func NewServer(base, root, redirect string) (*Server, error) {
 		return nil, fmt.Errorf("Missing or invalid root value")
 	}
 
-	// Create a new Server object
+	// Create a new Server object, and its initial configuration
 	s := new(Server)
+	c := new(config)
 
-	// Copy the values to the server
-	s.base = base
-	s.repo.SetRoot(root)
-	s.rootRedirect = root
+	// Copy the values to the configuration
+	c.base = base
+	c.repo.SetRoot(root)
+	c.rootRedirect = root
 
 	if redirect == "" {
 		redirect = root
 	}
-	s.redirect = redirect
+	c.redirect = redirect
 
 	// Set defaults for the new server object
-	s.repo.SetType("git")
-	s.webRoot = "./"
+	c.repo.SetType("git")
+	c.webRoot = "./"
 
-	s.queryRemote = true
+	c.queryRemote = true
 	s.client = new(http.Client)
 	s.client.Timeout = time.Second * 5
+	c.accessLog = NewAccessLogger(os.Stderr, LogText)
+	s.metrics = new(metrics)
+
+	s.limits = limits{
+		readHeaderTimeout: time.Second * 5,
+		readTimeout:       time.Second * 10,
+		writeTimeout:      time.Second * 10,
+		idleTimeout:       time.Second * 60,
+		maxHeaderBytes:    1 << 14,
+		maxConns:          1024,
+	}
+
+	c.redirectCodes = [numRedirectKinds]int{http.StatusFound, http.StatusFound, http.StatusFound}
+	c.lifetimes = lifetimes{
+		meta:     time.Hour,
+		redirect: time.Hour,
+		notFound: time.Minute,
+	}
+	c.modified = time.Now()
 
 	// Set the template
-	s.buildTemplate()
+	c.buildTemplate()
 
+	s.cfg.Store(c)
 	return s, nil
 }
 
 // String returns a string representation of the Server object
 func (s *Server) String() string {
-	out := fmt.Sprintln("Base URL:", s.base)
-	out += fmt.Sprintln("Root URL:", s.repo.root)
-	out += fmt.Sprintln("Redirect to:", s.redirect)
-	out += fmt.Sprintln("Redirect Root:", s.rootRedirect)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := s.config()
+	out := fmt.Sprintln("Base URL:", c.base)
+	out += fmt.Sprintln("Root URL:", c.repo.root)
+	out += fmt.Sprintln("Redirect to:", c.redirect)
+	out += fmt.Sprintln("Redirect Root:", c.rootRedirect)
+	if c.rootRepo != "" {
+		out += fmt.Sprintln("Root repository:", c.rootRepo)
+	}
+	if c.notFoundRedirect != "" {
+		out += fmt.Sprintln("Redirect Not Found:", c.notFoundRedirect)
+	}
+	out += fmt.Sprintln("Redirect codes:", c.redirectCodes[redirectPackage],
+		c.redirectCodes[redirectRoot], c.redirectCodes[redirectNotFound])
+	out += fmt.Sprintln("Preserve query:", c.preserveQuery)
 
-	if s.repo.provider != "" {
-		out += fmt.Sprintln("Provider:", s.repo.provider)
+	if c.repo.provider != "" {
+		out += fmt.Sprintln("Provider:", c.repo.provider)
+	}
+	out += fmt.Sprintln("VCS Type:", c.repo.vcsType)
+	if len(c.packages) > 0 {
+		out += fmt.Sprintln("Packages:", len(c.packages))
+	}
+	out += fmt.Sprintln("Index:", c.index)
+	if c.landing {
+		out += fmt.Sprintln("Landing page delay:", c.landingDelay)
+	}
+	out += fmt.Sprintln("Query Remote:", c.queryRemote)
+	_, ttl := s.cache.stats()
+	out += fmt.Sprintln("Cache TTL:", ttl)
+	if c.webRoot != "" {
+		out += fmt.Sprintln("Web root:", c.webRoot)
+	}
+	if s.healthPath != "" {
+		out += fmt.Sprintln("Health checks:", s.healthPath)
+	}
+	if s.apiPath != "" {
+		out += fmt.Sprintln("API:", s.apiPath)
+	}
+	if s.metricsPath != "" {
+		if s.metricsListener != nil {
+			out += fmt.Sprintln("Metrics:", s.metricsListener.Addr().String()+s.metricsPath)
+		} else {
+			out += fmt.Sprintln("Metrics:", s.metricsPath)
+		}
+	}
+	if s.adminListener != nil {
+		out += fmt.Sprintln("Admin API:", s.adminListener.Addr().String())
 	}
-	out += fmt.Sprintln("VCS Type:", s.repo.vcsType)
-	out += fmt.Sprintln("Query Remote:", s.queryRemote)
-	if s.webRoot != "" {
-		out += fmt.Sprintln("Web root:", s.webRoot)
+	if proxies := c.proxies.String(); proxies != "" {
+		out += fmt.Sprintln("Trusted proxies:", proxies)
 	}
+	out += fmt.Sprintln("Cache lifetimes:", c.lifetimes.meta, c.lifetimes.redirect, c.lifetimes.notFound)
+	out += fmt.Sprintln("Timeouts:", s.limits.readHeaderTimeout, s.limits.readTimeout,
+		s.limits.writeTimeout, s.limits.idleTimeout)
+	out += fmt.Sprintln("Max header bytes:", s.limits.maxHeaderBytes)
+	out += fmt.Sprintln("Max connections:", s.limits.maxConns)
 
 	return out
 }
 
-// Repo returns the pointer to the repo Vcs object so that it can
-// be configured by the application
+// Repo returns a Vcs object so that the repository can be configured by the
+// application. Changes made through it are applied to the configuration of
+// the server.
 func (s *Server) Repo() *Vcs {
-	return &s.repo
+	return &Vcs{server: s}
 }
 
-// RootRedirect changes the redirect for the `/` endpoint
+// RootRedirect changes the redirect for the `/` endpoint. The redirect may
+// contain placeholders, as described for NewServer.
 func (s *Server) RootRedirect(rr string) {
-	s.rootRedirect = rr
+	s.update(func(c *config) error {
+		c.rootRedirect = rr
+		return nil
+	})
+}
+
+// NotFoundRedirect sets the location to redirect browsers to, when the
+// requested package does not exist on the remote. The go tool still gets a
+// 404 response. The redirect may contain placeholders, as described for
+// NewServer. An empty value disables the redirect.
+func (s *Server) NotFoundRedirect(nf string) {
+	s.update(func(c *config) error {
+		c.notFoundRedirect = nf
+		return nil
+	})
+}
+
+// RootRepo sets the repository for the module whose path is the base itself,
+// e.g., `rsc.io` for the base `rsc.io`. The go tool is then served the
+// go-import meta tag for this repository on the root node, while browsers
+// are still redirected to the root redirect or served the index. An empty
+// value disables this.
+func (s *Server) RootRepo(repo string) {
+	s.update(func(c *config) error {
+		c.rootRepo = strings.TrimSuffix(strings.TrimSpace(repo), "/")
+		return nil
+	})
 }
 
 // WebRoot changes the web root for serving the `/.well-known/` folder
@@ -103,12 +205,18 @@ func (s *Server) WebRoot(wr string) error {
 		return fmt.Errorf("Web root %v is not a directory", wr)
 	}
 
-	s.webRoot = wr
-	return nil
+	return s.update(func(c *config) error {
+		c.webRoot = wr
+		return nil
+	})
 }
 
-// Listen changes the listening port/socket for the *Server
+// Listen changes the listening port/socket for the *Server. It only takes
+// effect when the server is started.
 func (s *Server) Listen(l net.Listener) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	if l != s.listener {
 		if s.listenerInit {
 			s.listener.Close()
@@ -123,39 +231,213 @@ func (s *Server) Listen(l net.Listener) {
 // the server to return 404 if the remote doesn't exist. However, this can
 // be disabled so that the server always assumes that the remote repo exists.
 func (s *Server) QueryRemote(query bool) {
-	s.queryRemote = query
+	s.update(func(c *config) error {
+		c.queryRemote = query
+		return nil
+	})
+}
+
+// CacheTTL controls how long the results of querying the remote are saved.
+// By default, this is 0, and the remote is queried on every request.
+func (s *Server) CacheTTL(ttl time.Duration) {
+	s.cache.setTTL(ttl)
+}
+
+// AccessLog sets the logger used to record every request handled by the
+// server. By default, requests are logged to stderr in the LogText format.
+// Setting it to nil disables the access log.
+func (s *Server) AccessLog(l AccessLogger) {
+	s.update(func(c *config) error {
+		c.accessLog = l
+		return nil
+	})
+}
+
+// TrustedProxies sets the list of reverse proxies, as CIDRs or IP addresses,
+// which are trusted to set the Forwarded and X-Forwarded-* headers. The
+// special value `unix` trusts all connections on a Unix domain socket.
+// Requests from any other address use the connection details directly.
+func (s *Server) TrustedProxies(cidrs []string) error {
+	p, err := parseProxies(cidrs)
+	if err != nil {
+		return err
+	}
+
+	return s.update(func(c *config) error {
+		c.proxies = p
+		return nil
+	})
+}
+
+// ServeMetrics enables the endpoint at path which serves the server metrics
+// in the Prometheus text exposition format. If l is nil, the endpoint is
+// served on the main listener, otherwise it is served on l only. An empty
+// path disables the endpoint. It only takes effect when the server is
+// started.
+func (s *Server) ServeMetrics(path string, l net.Listener) {
+	if path != "" && !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.metricsPath = path
+	s.metricsListener = l
+}
+
+// Timeouts configures the HTTP server timeouts. readHeader is the time
+// allowed to read the request headers, read is the time allowed to read the
+// entire request, write is the time allowed to write the response, and idle
+// is the time to wait for the next request on a keep-alive connection. A
+// zero value disables the corresponding timeout. The timeouts only take
+// effect when the server is started.
+func (s *Server) Timeouts(readHeader, read, write, idle time.Duration) error {
+	if readHeader < 0 || read < 0 || write < 0 || idle < 0 {
+		return fmt.Errorf("Invalid negative timeout")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.limits.readHeaderTimeout = readHeader
+	s.limits.readTimeout = read
+	s.limits.writeTimeout = write
+	s.limits.idleTimeout = idle
+	return nil
+}
+
+// MaxHeaderBytes controls the maximum number of bytes the server will read
+// while parsing the request headers. A zero value uses the net/http default.
+// It only takes effect when the server is started.
+func (s *Server) MaxHeaderBytes(n int) error {
+	if n < 0 {
+		return fmt.Errorf("Invalid max header bytes %v", n)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.limits.maxHeaderBytes = n
+	return nil
+}
+
+// MaxConns controls the maximum number of simultaneous connections that the
+// server will accept. Further connections will wait until an existing one
+// is closed. A zero value removes the limit. It only takes effect when the
+// server is started.
+func (s *Server) MaxConns(n int) error {
+	if n < 0 {
+		return fmt.Errorf("Invalid max connections %v", n)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.limits.maxConns = n
+	return nil
+}
+
+// newHTTPServer creates the HTTP server with the configured limits. The
+// caller must hold s.mu.
+func (s *Server) newHTTPServer(h http.Handler) *http.Server {
+	return &http.Server{
+		Handler:           h,
+		ReadHeaderTimeout: s.limits.readHeaderTimeout,
+		ReadTimeout:       s.limits.readTimeout,
+		WriteTimeout:      s.limits.writeTimeout,
+		IdleTimeout:       s.limits.idleTimeout,
+		MaxHeaderBytes:    s.limits.maxHeaderBytes,
+	}
 }
 
 // Serve serves the given vanity name as configured by the *Server object
 func (s *Server) Serve() error {
+	srv, l, err := s.start()
+	if err != nil {
+		return err
+	}
+
+	s.health.setServing(true)
+	err = srv.Serve(l)
+	s.health.setServing(false)
+	if err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	log.Printf("Finished")
+
+	return nil
+}
+
+// start creates the HTTP servers with the current settings, starts the
+// metrics and admin servers on their own listeners, and returns the main
+// HTTP server along with its limited listener. The settings are read under s.mu, so that they may be
+// changed concurrently, but later changes have no effect.
+func (s *Server) start() (*http.Server, net.Listener, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	m := http.NewServeMux()
-	s.httpServer = &http.Server{Handler: m}
+	s.httpServer = s.newHTTPServer(m)
 
-	m.HandleFunc("/.well-known/", getHandler(s.handleWellKnown))
-	m.HandleFunc("/robots.txt", getHandler(handleRobots))
-	m.HandleFunc("/robots.txt/", getHandler(http.NotFound))
-	m.HandleFunc("/", getHandler(s.handleGeneric))
+	handle := func(pattern string, h func(http.ResponseWriter, *http.Request)) {
+		m.HandleFunc(pattern, s.getHandler(pattern, h))
+	}
+
+	handle("/.well-known/", s.handleWellKnown)
+	handle("/robots.txt", handleRobots)
+	handle("/robots.txt/", http.NotFound)
+	handle("/", s.handleGeneric)
+
+	if s.healthPath != "" {
+		prefix := s.healthPath
+		if prefix == "/" {
+			prefix = ""
+		}
+		handle(prefix+"/live", s.handleLive)
+		handle(prefix+"/ready", s.handleReady)
+	}
+
+	if s.apiPath != "" {
+		handle(s.apiPath+"/resolve", s.handleResolve)
+	}
+
+	if s.metricsPath != "" {
+		if s.metricsListener == nil {
+			handle(s.metricsPath, s.handleMetrics)
+		} else {
+			mm := http.NewServeMux()
+			mm.HandleFunc(s.metricsPath, s.getHandler(s.metricsPath, s.handleMetrics))
+			s.metricsServer = s.newHTTPServer(mm)
+			go serveListener(s.metricsServer, s.metricsListener)
+		}
+	}
+
+	if s.adminListener != nil {
+		s.adminServer = s.newHTTPServer(s.AdminHandler(s.adminToken))
+		go serveListener(s.adminServer, s.adminListener)
+	}
 
 	if !s.listenerInit {
 		var err error
 		s.listener, err = net.Listen("tcp", "127.0.0.1:2369")
 		if err != nil {
-			return err
+			return nil, nil, err
 		}
 	}
 
-	if err := s.httpServer.Serve(s.listener); err != nil && err != http.ErrServerClosed {
-		return err
-	}
-	log.Printf("Finished")
+	return s.httpServer, limitListener(s.listener, s.limits.maxConns), nil
+}
 
-	return nil
+// serveListener serves the secondary HTTP server on l, and logs the error
+// if it fails
+func serveListener(srv *http.Server, l net.Listener) {
+	err := srv.Serve(l)
+	if err != nil && err != http.ErrServerClosed {
+		log.Print(err)
+	}
 }
 
 // handleWellKnown handles the "/.well-known/" directory and serves files
 // from it.
 func (s *Server) handleWellKnown(w http.ResponseWriter, r *http.Request) {
-	file := filepath.Join(s.webRoot, r.URL.Path)
+	file := filepath.Join(s.requestConfig(r).webRoot, r.URL.Path)
 
 	// Check if the file exists
 	info, err := os.Stat(file)
@@ -173,43 +455,118 @@ func (s *Server) handleWellKnown(w http.ResponseWriter, r *http.Request) {
 func (s *Server) handleGeneric(w http.ResponseWriter, r *http.Request) {
 	// Get the path to the requested image
 	module := r.URL.EscapedPath()
+	c := s.requestConfig(r)
 
-	// If the module is the root node, redirect to the root redirect
+	// If the module is the root node, serve the index or redirect to
+	// the root redirect. The go tool is served the root repository, if
+	// one is configured.
 	if module == "/" {
-		http.Redirect(w, r, s.rootRedirect, http.StatusFound)
+		if c.rootRepo != "" && isGoGet(r) {
+			if !s.upstreamExists(r, module) {
+				s.sendNotFound(w, r)
+				return
+			}
+			s.serveMeta(w, r, module)
+			return
+		}
+		if isGoGet(r) {
+			s.sendRedirect(w, r, redirectRoot, module)
+			return
+		}
+		s.serveBrowser(w, r, module, true)
+		return
+	}
+
+	// Reject invalid paths before making any upstream requests
+	if err := checkModulePath(module); err != nil {
+		code := pathErrorCode(err)
+		if code == http.StatusNotFound {
+			s.sendNotFound(w, r)
+		} else {
+			http.Error(w, http.StatusText(code), code)
+		}
+		return
+	}
+
+	// Make sure that the upstream exists, and serve browsers the redirect
+	// or the landing page if there is no go-get=1 in the query
+	exists := s.upstreamExists(r, module)
+	if !isGoGet(r) {
+		s.serveBrowser(w, r, module, exists)
 		return
 	}
 
-	// Make sure that the upstream exists
-	exists, _ := s.checkUpstream(module)
 	if !exists {
-		http.NotFound(w, r)
+		s.sendNotFound(w, r)
 		return
 	}
 
-	// Check if we got go-get=1 in the query
-	redirect := func(r *http.Request) bool {
-		get, ok := r.URL.Query()["go-get"]
+	s.serveMeta(w, r, module)
+}
 
-		if !ok {
-			// go-get was not in the query
-			// Redirect to the redirect URL
-			return true
-		}
+// browserAction is the response to a browser request for a module
+type browserAction int
 
-		// Search all the values for a matching one
-		for _, v := range get {
-			if v == "1" {
-				return false
-			}
-		}
+const (
+	// browserRedirect redirects the browser
+	browserRedirect browserAction = iota
 
-		return true
+	// browserLanding serves the landing page
+	browserLanding
+
+	// browserIndex serves the package index
+	browserIndex
+
+	// browserNotFound responds with a 404
+	browserNotFound
+)
+
+// String returns the name of the action, as used by the resolve API
+func (a browserAction) String() string {
+	switch a {
+	case browserLanding:
+		return "landing"
+	case browserIndex:
+		return "index"
+	case browserNotFound:
+		return "not_found"
+	default:
+		return "redirect"
 	}
-	if redirect(r) {
-		http.Redirect(w, r, s.getRedirect(module), http.StatusFound)
-		return
+}
+
+// browserAction returns how browsers are answered for the module, and the
+// kind of redirect if they are redirected. exists is set if the upstream
+// repository exists, and is ignored for the root node.
+func (c *config) browserAction(module string, exists bool) (browserAction, redirectKind) {
+	switch {
+	case module == "/" && c.index:
+		return browserIndex, redirectRoot
+	case module == "/":
+		return browserRedirect, redirectRoot
+	case !exists && c.notFoundRedirect != "":
+		return browserRedirect, redirectNotFound
+	case !exists:
+		return browserNotFound, redirectNotFound
+	case c.landing:
+		return browserLanding, redirectPackage
+	default:
+		return browserRedirect, redirectPackage
 	}
+}
 
-	s.serveMeta(w, module)
+// serveBrowser answers a browser request for the module, as decided by
+// browserAction
+func (s *Server) serveBrowser(w http.ResponseWriter, r *http.Request, module string, exists bool) {
+	action, kind := s.requestConfig(r).browserAction(module, exists)
+	switch action {
+	case browserIndex:
+		s.handleIndex(w, r)
+	case browserNotFound:
+		s.sendNotFound(w, r)
+	case browserLanding:
+		s.serveLanding(w, r, module)
+	default:
+		s.sendRedirect(w, r, kind, module)
+	}
 }
@@ -4,7 +4,9 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestGeneric(t *testing.T) {
@@ -128,6 +130,56 @@ func TestRootRedirect(t *testing.T) {
 	}
 }
 
+func TestRootRepo(t *testing.T) {
+	mock := mockServer(t)
+	defer mock.Close()
+
+	s, _ := NewServer("example.com", mockAddr(mock), "")
+	s.client = mock.Client()
+	s.RootRedirect("https://example.com/about")
+
+	checks := []struct {
+		repo     string
+		index    bool
+		path     string
+		code     int
+		location string
+		body     string
+	}{
+		{"", false, "/?go-get=1", http.StatusFound, "https://example.com/about", ""},
+		{mockAddr(mock) + "valid/", false, "/?go-get=1", http.StatusOK, "",
+			`<meta name="go-import" content="example.com git ` + mockAddr(mock) + `valid">`},
+		{mockAddr(mock) + "valid", false, "/", http.StatusFound, "https://example.com/about", ""},
+		{mockAddr(mock) + "valid", true, "/", http.StatusOK, "", "<h1>example.com</h1>"},
+		{mockAddr(mock) + "missing", false, "/?go-get=1", http.StatusNotFound, "", ""},
+	}
+
+	for _, c := range checks {
+		s.RootRepo(c.repo)
+		s.Index(c.index)
+
+		rr := httptest.NewRecorder()
+		s.handleGeneric(rr, httptest.NewRequest("GET", c.path, nil))
+
+		if rr.Code != c.code || rr.Header().Get("Location") != c.location {
+			t.Errorf("Mismatch for %v with root repo %#v, expected (%v, %#v), got (%v, %#v)",
+				c.path, c.repo, c.code, c.location, rr.Code, rr.Header().Get("Location"))
+		}
+		if !strings.Contains(rr.Body.String(), c.body) {
+			t.Errorf("Missing %v in response for %v:\n%v", c.body, c.path, rr.Body.String())
+		}
+	}
+
+	s.RootRepo("https://git.example.com/root")
+	res, err := s.Resolve("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.ImportPath != "example.com" || res.GoImport != "example.com git https://git.example.com/root" {
+		t.Errorf("Unexpected resolution for the root %#v", res)
+	}
+}
+
 func TestWellKnown(t *testing.T) {
 	s, _ := NewServer("base", "root", "")
 	s.WebRoot("/")
@@ -159,6 +211,9 @@ func TestWellKnown(t *testing.T) {
 }
 
 func TestGetHandler(t *testing.T) {
+	s, _ := NewServer("base", "root", "")
+	s.AccessLog(nil)
+
 	dummy := func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte("Hello, World!\n"))
 	}
@@ -166,13 +221,15 @@ func TestGetHandler(t *testing.T) {
 	checks := []struct {
 		method string
 		code   int
+		body   string
+		allow  bool
 	}{
-		{"GET", http.StatusOK},
-		{"POST", http.StatusMethodNotAllowed},
-		{"PUT", http.StatusMethodNotAllowed},
-		{"PATCH", http.StatusMethodNotAllowed},
-		{"HEAD", http.StatusMethodNotAllowed},
-		{"OPTIONS", http.StatusMethodNotAllowed},
+		{"GET", http.StatusOK, "Hello, World!\n", false},
+		{"HEAD", http.StatusOK, "", false},
+		{"OPTIONS", http.StatusNoContent, "", true},
+		{"POST", http.StatusMethodNotAllowed, "Method Not Allowed\n", true},
+		{"PUT", http.StatusMethodNotAllowed, "Method Not Allowed\n", true},
+		{"PATCH", http.StatusMethodNotAllowed, "Method Not Allowed\n", true},
 	}
 
 	for _, c := range checks {
@@ -183,10 +240,52 @@ func TestGetHandler(t *testing.T) {
 
 		rr := httptest.NewRecorder()
 
-		getHandler(dummy)(rr, req)
+		s.getHandler("/", dummy)(rr, req)
 
 		if c.code != rr.Code {
-			t.Errorf("Unexpected status code %v, expected %v", rr.Code, c.code)
+			t.Errorf("%v: Unexpected status code %v, expected %v", c.method, rr.Code, c.code)
+		}
+
+		if body := rr.Body.String(); body != c.body {
+			t.Errorf("%v: Unexpected body %#v, expected %#v", c.method, body, c.body)
 		}
+
+		allow := rr.Header().Get("Allow")
+		if c.allow && allow != allowedMethods {
+			t.Errorf("%v: Unexpected Allow header %#v, expected %#v", c.method, allow, allowedMethods)
+		} else if !c.allow && allow != "" {
+			t.Errorf("%v: Unexpected Allow header %#v", c.method, allow)
+		}
+	}
+}
+
+func TestLimits(t *testing.T) {
+	s, _ := NewServer("base", "root", "")
+
+	if err := s.Timeouts(time.Second, 2*time.Second, 3*time.Second, 4*time.Second); err != nil {
+		t.Fatalf("Expected nil, got error %v", err)
+	}
+	if err := s.MaxHeaderBytes(1024); err != nil {
+		t.Fatalf("Expected nil, got error %v", err)
+	}
+
+	hs := s.newHTTPServer(http.NotFoundHandler())
+	if hs.ReadHeaderTimeout != time.Second || hs.ReadTimeout != 2*time.Second ||
+		hs.WriteTimeout != 3*time.Second || hs.IdleTimeout != 4*time.Second {
+		t.Errorf("Mismatch in HTTP server timeouts %v %v %v %v", hs.ReadHeaderTimeout,
+			hs.ReadTimeout, hs.WriteTimeout, hs.IdleTimeout)
+	}
+	if hs.MaxHeaderBytes != 1024 {
+		t.Errorf("Mismatch in MaxHeaderBytes, expected 1024, got %v", hs.MaxHeaderBytes)
+	}
+
+	if err := s.Timeouts(-1, 0, 0, 0); err == nil {
+		t.Errorf("Expected error for negative timeout, got nil")
+	}
+	if err := s.MaxHeaderBytes(-1); err == nil {
+		t.Errorf("Expected error for negative max header bytes, got nil")
+	}
+	if err := s.MaxConns(-1); err == nil {
+		t.Errorf("Expected error for negative max connections, got nil")
 	}
 }
@@ -6,6 +6,9 @@ import (
 	"html/template"
 	"net"
 	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // Vcs is a configuration structure to configure the version control system
@@ -30,11 +33,97 @@ type Vcs struct {
 
 	// provider is the VCS provider platform, e.g. Github.
 	provider string
+
+	// branch is the default branch used in the provider's templates. It
+	// is replaced with the requested version when linking to the source
+	// at a specific version, and is empty for custom templates.
+	branch string
+
+	// server is set if the Vcs was returned by Server.Repo, in which case
+	// the changes are applied to the configuration of the server.
+	server *Server
 }
 
 // Server is a configuration structure to adjust the attributes of the vanity
-// URL responder
+// URL responder. The configuration used to handle requests may be changed
+// while the server is running. The listeners, limits, and the paths of the
+// metrics, health and API endpoints are only used when the server is started.
 type Server struct {
+	// mu serializes the changes to the configuration. It also guards the
+	// listeners, HTTP servers, endpoint paths, admin settings and limits,
+	// which may be set while the server is running, but are only used when
+	// it is started.
+	mu sync.Mutex
+
+	// storeMu serializes the changes made through the admin API, which
+	// are saved to adminStore before the configuration is changed
+	storeMu sync.Mutex
+
+	// cfg holds the current *config. It is replaced as a whole whenever
+	// the configuration is changed, and is never modified in place.
+	cfg atomic.Value
+
+	// listener is the port/socket on which to listen to. The default
+	// is tcp:2369
+	listener net.Listener
+
+	// listenerInit is a flag that indicates if the listener has been
+	// initialized. It is used when the user has not created a custom
+	// listener, and the server must fallback to the default listener.
+	listenerInit bool
+
+	// httpServer is a reference to the HTTP server, it is used during
+	// initial bringup and final shutdown.
+	httpServer *http.Server
+
+	// client is a reference to the HTTP client used for querying the
+	// upstream server. A default client is created when the server is
+	// initialized, but it can be swapped with a separate client for
+	// test purposes.
+	client *http.Client
+
+	// cache saves the results of the upstream checks
+	cache upstreamCache
+
+	// metrics collects the request and upstream statistics
+	metrics *metrics
+
+	// metricsPath is the path of the metrics endpoint, and is empty if
+	// the endpoint is disabled. If metricsListener is set, the endpoint
+	// is served on metricsListener by metricsServer instead of the main
+	// listener.
+	metricsPath     string
+	metricsListener net.Listener
+	metricsServer   *http.Server
+
+	// health tracks the listener and upstream state for the readiness
+	// endpoint, which is served under healthPath if it is not empty.
+	health     health
+	healthPath string
+
+	// apiPath is the prefix of the JSON API endpoints, and is empty if the
+	// API is disabled
+	apiPath string
+
+	// adminListener is the listener for the admin API, which is disabled
+	// if it is nil. adminToken is the bearer token required by the API,
+	// and adminStore saves the packages after they are changed.
+	adminListener net.Listener
+	adminToken    string
+	adminStore    func(packages []Package) error
+	adminServer   *http.Server
+
+	// limits holds the timeouts and size limits applied to httpServer
+	// and the listener when the server is started.
+	limits limits
+}
+
+// config is the part of the Server configuration which is used to handle
+// requests. A config is never modified once it is in use by the Server.
+// Changes are made to a copy, which then replaces the current config, so
+// that a running server can be reconfigured safely, and each request sees
+// a consistent configuration.
+type config struct {
 	// base is the base URL to which the vanity name is bound.  E.g., for
 	// the vanity name `rsc.io/quote/v3`, the BaseURL is `rsc.io`.
 	base string
@@ -47,7 +136,8 @@ type Server struct {
 	// to the vanity URL. E.g., navigating to `https://rsc.io/quote/v3`
 	// redirects to `https://godoc.org/rsc.io/quote/v3`. The value of redirect
 	// in this example is `https://godoc.org/rsc.io`. If this is empty, it
-	// defaults to the contents of `repo.root`.
+	// defaults to the contents of `repo.root`. It may also be a template
+	// with placeholders, e.g., `https://pkg.go.dev/{importpath}`.
 	redirect string
 
 	// webRoot is the location where to serve the contents of `.well-known`
@@ -60,30 +150,84 @@ type Server struct {
 	// 200 or 302 code, even if the repository doesn't exist on the remote.
 	queryRemote bool
 
-	// listener is the port/socket on which to listen to. The default
-	// is tcp:2369
-	listener net.Listener
+	// packages is the table of known packages, indexed by name
+	packages map[string]Package
 
-	// listenerInit is a flag that indicates if the listener has been
-	// initialized. It is used when the user has not created a custom
-	// listener, and the server must fallback to the default listener.
-	listenerInit bool
+	// index enables the package index at the root, instead of redirecting
+	// browsers to rootRedirect
+	index bool
+
+	// landing enables serving a landing page to browsers, which redirects
+	// to the browser redirect after landingDelay, if it is positive.
+	landing      bool
+	landingDelay time.Duration
 
 	// root is the location to redirect the request to the root node "/".
 	// This defaults to repo.root, but it may be overridden by RootRedirect
 	rootRedirect string
 
+	// rootRepo is the repository for the module at the base itself. If it
+	// is set, go-get requests for the root node are served the go-import
+	// meta tag for this repository.
+	rootRepo string
+
+	// notFoundRedirect is the location to redirect browsers to when the
+	// requested package does not exist. If this is empty, the server
+	// responds with a 404 instead.
+	notFoundRedirect string
+
+	// redirectCodes is the HTTP status code for each kind of redirect
+	redirectCodes [numRedirectKinds]int
+
+	// preserveQuery is a flag that adds the request query parameters to
+	// the browser redirect location.
+	preserveQuery bool
+
 	// template is used by the server to save the template pointer.
 	// This is used by handleGeneric to return the formatted data.
 	template *template.Template
 
-	// httpServer is a reference to the HTTP server, it is used during
-	// initial bringup and final shutdown.
-	httpServer *http.Server
+	// modified is the time at which the configuration was last changed.
+	// It is sent as the Last-Modified time of the meta pages. replaced is
+	// the modification time of the configuration which this one replaced,
+	// and is used to tell if both have the same Last-Modified time.
+	modified time.Time
+	replaced time.Time
 
-	// client is a reference to the HTTP client used for querying the
-	// upstream server. A default client is created when the server is
-	// initialized, but it can be swapped with a separate client for
-	// test purposes.
-	client *http.Client
+	// lifetimes sets the Cache-Control header for each kind of response
+	lifetimes lifetimes
+
+	// accessLog records every request handled by the server
+	accessLog AccessLogger
+
+	// proxies is the list of trusted reverse proxies, whose forwarding
+	// headers are used to determine the client address, host and protocol
+	proxies proxies
+}
+
+// limits is a configuration structure for the timeouts and size limits of
+// the HTTP server. A zero value disables the corresponding limit.
+type limits struct {
+	// readHeaderTimeout is the amount of time allowed to read the request
+	// headers. This protects against slowloris style attacks.
+	readHeaderTimeout time.Duration
+
+	// readTimeout is the maximum duration for reading the entire request.
+	readTimeout time.Duration
+
+	// writeTimeout is the maximum duration before timing out writes of the
+	// response.
+	writeTimeout time.Duration
+
+	// idleTimeout is the maximum amount of time to wait for the next
+	// request when keep-alives are enabled.
+	idleTimeout time.Duration
+
+	// maxHeaderBytes is the maximum number of bytes the server will read
+	// while parsing the request headers.
+	maxHeaderBytes int
+
+	// maxConns is the maximum number of simultaneous connections that the
+	// server will accept.
+	maxConns int
 }
@@ -3,66 +3,229 @@
 package vanity
 
 import (
+	"bytes"
+	"fmt"
 	"html/template"
-	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
 	"strings"
 )
 
-// buildTemplate builds the template structure and saves it
-// in the *Server object
-func (s *Server) buildTemplate() {
-	const tpl = `<!DOCTYPE html>
+// TemplateData is the data passed to the HTML template when rendering the
+// page for a go-get request. Custom templates may use any of these fields,
+// but must include the GoImport value in a go-import meta tag for the go
+// tool to find the repository.
+type TemplateData struct {
+	// Base is the base URL of the vanity server, e.g., `rsc.io`
+	Base string
+
+	// Pkg is the first path element of the request, e.g., `quote`
+	Pkg string
+
+	// VcsHost is the root of the VCS host, e.g., `https://github.com/rsc/`
+	VcsHost string
+
+	// VcsType is the version control system identifier, e.g., `git`
+	VcsType string
+
+	// Redirect is the configured browser redirect prefix
+	Redirect string
+
+	// Request is the full request path, without the leading slash, e.g.,
+	// `quote/v3`
+	Request string
+
+	// Dir and File are the go-source URL templates for directories and
+	// files, relative to the repository URL.
+	Dir  string
+	File string
+
+	// ImportPath is the import prefix of the repository, e.g., `rsc.io/quote`
+	ImportPath string
+
+	// RepoURL is the URL of the repository, e.g., `https://github.com/rsc/quote`
+	RepoURL string
+
+	// GoImport is the content of the go-import meta tag
+	GoImport string
+
+	// GoSource is the content of the go-source meta tag, and is empty if
+	// neither of the Dir and File templates are set.
+	GoSource string
+
+	// RedirectURL is the location where browsers are redirected to, and
+	// is empty if the location fails the redirect check.
+	RedirectURL string
+
+	// Docs is the URL of the package documentation, and is empty if it
+	// fails the redirect check.
+	Docs string
+
+	// SourceURL is the URL of the requested directory in the repository,
+	// at the requested version if the request had an `@version` suffix.
+	SourceURL string
+
+	// Description and Version are the description and the latest known
+	// version of the package, and are empty if they are not known.
+	Description string
+	Version     string
+}
+
+// defaultTemplate is the built-in template for the go-get response
+const defaultTemplate = `<!DOCTYPE html>
 <html>
 <head>
-{{- $base := printf "%s/%s" .Base .Pkg -}}
-{{- $host := printf "%s%s" .VcsHost .Pkg -}}
-{{- $import := printf "%s %s %s" $base .VcsType $host -}}
-{{- $redirect := .Pkg -}}
-{{- if ne .Redirect .VcsHost -}}
-{{- $redirect = printf "%s%s" .Redirect .Request -}}
-{{- else -}}
-{{- $redirect = printf "%s%s" .Redirect .Pkg -}}
-{{- end  }}
 	<meta charset="UTF-8">
-	<meta name="go-import" content="{{ $import }}">
-{{- if or .Dir .File -}}
-{{- $dir := (printf "%s/%s" $host .Dir) -}}{{- if not .Dir -}}{{- $dir = "_" -}}{{- end -}}
-{{- $file := (printf "%s/%s" $host .File) -}}{{- if not .File -}}{{- $file = "_" -}}{{- end -}}
-{{- $source := (printf "%s %s %s %s" $base $host $dir $file)  }}
-	<meta name="go-source" content="{{ $source }}">
-{{- end  }}
-	<meta http-equiv="refresh" content="0;url={{$redirect}}">
+	<meta name="go-import" content="{{ .GoImport }}">
+{{- if .GoSource }}
+	<meta name="go-source" content="{{ .GoSource }}">
+{{- end }}
+{{- if .RedirectURL }}
+	<meta http-equiv="refresh" content="0;url={{ .RedirectURL }}">
+{{- end }}
 </head>
 <body>
-<p>Redirecting to <a href="{{$redirect}}">{{$redirect}}</a></p>
+{{- if .RedirectURL }}
+<p>Redirecting to <a href="{{ .RedirectURL }}">{{ .RedirectURL }}</a></p>
+{{- end }}
 </body>
 </html>`
 
-	s.template = template.Must(template.New("vanity").Parse(tpl))
+// goImportTag renders the go-import meta tag that the go tool looks for. It
+// is used to check that custom templates include the tag, escaped the same
+// way as the template escapes it.
+var goImportTag = template.Must(template.New("go-import").Parse(`<meta name="go-import" content="{{ . }}">`))
+
+// buildTemplate builds the template structure and saves it
+// in the configuration
+func (c *config) buildTemplate() {
+	c.template = template.Must(template.New("vanity").Parse(defaultTemplate))
 }
 
-func (s *Server) serveMeta(w io.Writer, req string) {
-	pkg := repoBase(req)
-
-	tplData := struct {
-		Base     string
-		Pkg      string
-		VcsHost  string
-		VcsType  string
-		Redirect string
-		Request  string
-		Dir      string
-		File     string
-	}{
-		Base:     s.base,
-		Pkg:      pkg,
-		VcsHost:  s.repo.root,
-		VcsType:  s.repo.vcsType,
-		Redirect: s.redirect,
-		Request:  strings.TrimPrefix(req, "/"),
-		Dir:      s.repo.dirFormat,
-		File:     s.repo.fileFormat,
+// Template replaces the built-in HTML template for the go-get response
+// with the given html/template text. The template is executed with a
+// *TemplateData value. It is validated by rendering a sample page, so
+// that errors are reported immediately rather than on the first request.
+// The sample page must contain the tag
+// `<meta name="go-import" content="{{ .GoImport }}">`, since the go tool
+// can't find the repository without it.
+func (s *Server) Template(text string) error {
+	tpl, err := template.New("vanity").Parse(text)
+	if err != nil {
+		return err
+	}
+
+	return s.update(func(c *config) error {
+		// Validate the template against sample data
+		sample := c.templateData("/example/sub")
+		var out, tag bytes.Buffer
+		if err := tpl.Execute(&out, sample); err != nil {
+			return err
+		}
+
+		goImportTag.Execute(&tag, sample.GoImport)
+		if !bytes.Contains(out.Bytes(), tag.Bytes()) {
+			return fmt.Errorf("Template output is missing the go-import meta tag %v", tag.String())
+		}
+
+		c.template = tpl
+		return nil
+	})
+}
+
+// TemplateFile reads the HTML template from the given file, and uses it
+// in place of the built-in template. See Template for details.
+func (s *Server) TemplateFile(path string) error {
+	text, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	if err := s.Template(string(text)); err != nil {
+		return fmt.Errorf("Invalid template %v: %v", path, err)
+	}
+	return nil
+}
+
+// goSource returns the content of the go-source meta tag for the repository
+func (c *config) goSource(importPath, repoURL string) string {
+	if c.repo.dirFormat == "" && c.repo.fileFormat == "" {
+		return ""
+	}
+
+	dir, file := "_", "_"
+	if c.repo.dirFormat != "" {
+		dir = repoURL + "/" + c.repo.dirFormat
+	}
+	if c.repo.fileFormat != "" {
+		file = repoURL + "/" + c.repo.fileFormat
+	}
+
+	return strings.Join([]string{importPath, repoURL, dir, file}, " ")
+}
+
+// templateData builds the template data for the requested path
+func (c *config) templateData(req string) *TemplateData {
+	m := parseModulePath(req)
+	pkg := m.pkg
+	importPath := c.base
+	if pkg != "" {
+		importPath += "/" + pkg
+	}
+	repoURL := c.repoURL(pkg)
+
+	return &TemplateData{
+		Base:        c.base,
+		Pkg:         pkg,
+		VcsHost:     c.repo.root,
+		VcsType:     c.repo.vcsType,
+		Redirect:    c.redirect,
+		Request:     strings.TrimPrefix(req, "/"),
+		Dir:         c.repo.dirFormat,
+		File:        c.repo.fileFormat,
+		ImportPath:  importPath,
+		RepoURL:     repoURL,
+		GoImport:    strings.Join([]string{importPath, c.repo.vcsType, repoURL}, " "),
+		GoSource:    c.goSource(importPath, repoURL),
+		RedirectURL: c.allowedRedirect(c.getRedirect(req, "")),
+		Docs:        c.allowedRedirect(c.docsURL(pkg)),
+		SourceURL:   c.repo.sourceURL(repoURL, m.version, m.sourceDir()),
+		Description: c.packages[pkg].Description,
+		Version:     c.packages[pkg].Version,
+	}
+}
+
+// serveMeta renders the meta page for the request, and sends it with the
+// caching headers. If the client already has a fresh copy, it responds with
+// 304 Not Modified instead.
+func (s *Server) serveMeta(w http.ResponseWriter, r *http.Request, req string) {
+	c := s.requestConfig(r)
+	c.serveHTML(w, r, c.template, c.templateData(req))
+}
+
+// serveHTML renders the template with the data, and sends it with the
+// caching headers for meta pages. If the client already has a fresh copy,
+// it responds with 304 Not Modified instead.
+func (c *config) serveHTML(w http.ResponseWriter, r *http.Request, tpl *template.Template, data interface{}) {
+	var buf bytes.Buffer
+	if err := tpl.Execute(&buf, data); err != nil {
+		log.Print(err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	etag := computeETag(buf.Bytes())
+	h := w.Header()
+	h.Set("ETag", etag)
+	h.Set("Last-Modified", c.modified.UTC().Format(http.TimeFormat))
+	setCacheControl(w, c.lifetimes.meta)
+
+	if notModified(r, etag, c.modified, c.replaced) {
+		w.WriteHeader(http.StatusNotModified)
+		return
 	}
 
-	s.template.Execute(w, tplData)
+	h.Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(buf.Bytes())
 }
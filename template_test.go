@@ -0,0 +1,107 @@
+// Copyright 2019 Nirenjan Krishnan. All rights reserved.
+
+package vanity
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDefaultTemplate(t *testing.T) {
+	s, _ := NewServer("nirenjan.org", "https://github.com/nirenjan/", "https://godoc.org/nirenjan.org/")
+	s.Repo().SetProvider("github")
+
+	rr := httptest.NewRecorder()
+	s.serveMeta(rr, httptest.NewRequest("GET", "/semver/core?go-get=1", nil), "/semver/core")
+
+	expected := `<!DOCTYPE html>
+<html>
+<head>
+	<meta charset="UTF-8">
+	<meta name="go-import" content="nirenjan.org/semver git https://github.com/nirenjan/semver">
+	<meta name="go-source" content="nirenjan.org/semver https://github.com/nirenjan/semver https://github.com/nirenjan/semver/tree/master{/dir} https://github.com/nirenjan/semver/blob/master{/dir}/{file}#L{line}">
+	<meta http-equiv="refresh" content="0;url=https://godoc.org/nirenjan.org/semver/core">
+</head>
+<body>
+<p>Redirecting to <a href="https://godoc.org/nirenjan.org/semver/core">https://godoc.org/nirenjan.org/semver/core</a></p>
+</body>
+</html>`
+
+	if body := rr.Body.String(); body != expected {
+		t.Errorf("Mismatch in default template, expected:\n%s\ngot:\n%s", expected, body)
+	}
+}
+
+func TestCustomTemplate(t *testing.T) {
+	s, _ := NewServer("nirenjan.org", "https://github.com/nirenjan/", "")
+
+	err := s.Template(`<meta name="go-import" content="{{ .GoImport }}"><h1>{{ .ImportPath }}</h1>{{ .GoSource }}`)
+	if err != nil {
+		t.Fatalf("Expected nil, got error %v", err)
+	}
+
+	rr := httptest.NewRecorder()
+	s.serveMeta(rr, httptest.NewRequest("GET", "/semver?go-get=1", nil), "/semver")
+
+	expected := `<meta name="go-import" content="nirenjan.org/semver git https://github.com/nirenjan/semver"><h1>nirenjan.org/semver</h1>`
+	if body := rr.Body.String(); body != expected {
+		t.Errorf("Mismatch in custom template, expected %#v, got %#v", expected, body)
+	}
+}
+
+func TestTemplateInvalid(t *testing.T) {
+	s, _ := NewServer("nirenjan.org", "https://github.com/nirenjan/", "")
+
+	checks := []string{
+		// Parse error
+		`{{ .GoImport `,
+		// Execution error
+		`{{ .NoSuchField }}`,
+		// Missing go-import meta tag
+		`<h1>{{ .ImportPath }}</h1>`,
+		// Go-import meta tag with the wrong content
+		`<meta name="go-import" content="{{ .ImportPath }}">`,
+	}
+
+	for _, c := range checks {
+		if err := s.Template(c); err == nil {
+			t.Errorf("Expected error for template %#v, got nil", c)
+		}
+	}
+
+	// The existing template is retained
+	rr := httptest.NewRecorder()
+	s.serveMeta(rr, httptest.NewRequest("GET", "/semver?go-get=1", nil), "/semver")
+	if rr.Code != 200 || rr.Body.Len() == 0 {
+		t.Errorf("Unexpected response after invalid template %v %#v", rr.Code, rr.Body.String())
+	}
+}
+
+func TestTemplateFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vanity")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, _ := NewServer("nirenjan.org", "https://github.com/nirenjan/", "")
+
+	good := filepath.Join(dir, "good.html")
+	ioutil.WriteFile(good, []byte(`<meta name="go-import" content="{{ .GoImport }}">{{ .RepoURL }}`), 0644)
+	if err := s.TemplateFile(good); err != nil {
+		t.Errorf("Expected nil, got error %v", err)
+	}
+
+	bad := filepath.Join(dir, "bad.html")
+	ioutil.WriteFile(bad, []byte(`{{ .Bad }}`), 0644)
+	if err := s.TemplateFile(bad); err == nil {
+		t.Errorf("Expected error, got nil")
+	}
+
+	if err := s.TemplateFile(filepath.Join(dir, "missing.html")); err == nil {
+		t.Errorf("Expected error, got nil")
+	}
+}
@@ -9,74 +9,122 @@ import (
 
 // This file manages the VCS structure
 
+// update applies the change to the Vcs. If the Vcs was returned by
+// Server.Repo, the change is applied to the configuration of the server.
+func (v *Vcs) update(change func(r *Vcs) error) error {
+	if v.server == nil {
+		return change(v)
+	}
+
+	return v.server.update(func(c *config) error {
+		return change(&c.repo)
+	})
+}
+
 // SetRoot configures the root directory of the hosting provider where the
 // package is hosted.
 func (v *Vcs) SetRoot(r string) {
-	v.root = r
+	v.update(func(v *Vcs) error {
+		v.root = r
+		return nil
+	})
 }
 
 // SetProvider configures the Vcs structure to use the corresponding provider
 func (v *Vcs) SetProvider(provider string) error {
-	switch strings.TrimSpace(strings.ToLower(provider)) {
-	case "github", "gitlab":
-		v.vcsType = "git"
-		v.dirFormat = "tree/master{/dir}"
-		v.fileFormat = "blob/master{/dir}/{file}#L{line}"
-
-	case "bitbucket", "gogs", "gitea":
-		// Default vcsType for Bitbucket is git, since Bitbucket is
-		// sunsetting the mercurial repositories.
-		v.vcsType = "git"
-		v.dirFormat = "src/master{/dir}"
-		v.fileFormat = "src/master{/dir}/{file}#L{line}"
-
-	default:
-		return fmt.Errorf("Unknown provider %v", provider)
-	}
-
-	v.provider = provider
-	return nil
+	return v.update(func(v *Vcs) error {
+		switch strings.TrimSpace(strings.ToLower(provider)) {
+		case "github", "gitlab":
+			v.vcsType = "git"
+			v.dirFormat = "tree/master{/dir}"
+			v.fileFormat = "blob/master{/dir}/{file}#L{line}"
+			v.branch = "master"
+
+		case "bitbucket", "gogs", "gitea":
+			// Default vcsType for Bitbucket is git, since Bitbucket is
+			// sunsetting the mercurial repositories.
+			v.vcsType = "git"
+			v.dirFormat = "src/master{/dir}"
+			v.fileFormat = "src/master{/dir}/{file}#L{line}"
+			v.branch = "master"
+
+		default:
+			return fmt.Errorf("Unknown provider %v", provider)
+		}
+
+		v.provider = provider
+		return nil
+	})
 }
 
 // SetType sets the version control system type.
 // It can be one of the following case-insensitive strings:
 // Bazaar, Fossil, Git, Mercurial, Subversion
 func (v *Vcs) SetType(t string) error {
-	switch strings.TrimSpace(strings.ToLower(t)) {
-	case "bazaar":
-		v.vcsType = "bzr"
+	return v.update(func(v *Vcs) error {
+		switch strings.TrimSpace(strings.ToLower(t)) {
+		case "bazaar":
+			v.vcsType = "bzr"
 
-	case "fossil":
-		v.vcsType = "fossil"
+		case "fossil":
+			v.vcsType = "fossil"
 
-	case "git":
-		v.vcsType = "git"
+		case "git":
+			v.vcsType = "git"
 
-	case "mercurial":
-		v.vcsType = "hg"
+		case "mercurial":
+			v.vcsType = "hg"
 
-	case "subversion":
-		v.vcsType = "svn"
+		case "subversion":
+			v.vcsType = "svn"
 
-	default:
-		return fmt.Errorf("Unknown VCS type %v", t)
-	}
+		default:
+			return fmt.Errorf("Unknown VCS type %v", t)
+		}
 
-	return nil
+		return nil
+	})
 }
 
 // SetTemplates sets the URL templates for the directory and file
 // listings. These are used by godoc to map the identifiers back to
 // the source listings.
 func (v *Vcs) SetTemplates(dir, file string) error {
-	// Check the file template, if it is not empty, it should
-	// contain at least one instance of {file}.
-	if file != "" && !strings.Contains(file, "{file}") {
-		return fmt.Errorf("Invalid file template %v", file)
+	return v.update(func(v *Vcs) error {
+		// Check the file template, if it is not empty, it should
+		// contain at least one instance of {file}.
+		if file != "" && !strings.Contains(file, "{file}") {
+			return fmt.Errorf("Invalid file template %v", file)
+		}
+
+		v.dirFormat = dir
+		v.fileFormat = file
+		v.branch = ""
+
+		return nil
+	})
+}
+
+// sourceURL returns the URL of the directory dir in the repository, using
+// the directory template. If ref is not empty, and the template is one of
+// the provider defaults, the URL points to the directory at that ref
+// instead of the default branch.
+func (v *Vcs) sourceURL(repoURL, ref, dir string) string {
+	if v.dirFormat == "" {
+		return repoURL
 	}
 
-	v.dirFormat = dir
-	v.fileFormat = file
+	format := v.dirFormat
+	if ref != "" && v.branch != "" {
+		format = strings.Replace(format, v.branch, ref, 1)
+	}
+
+	if dir != "" {
+		format = strings.Replace(format, "{/dir}", "/"+dir, -1)
+	} else {
+		format = strings.Replace(format, "{/dir}", "", -1)
+	}
+	format = strings.Replace(format, "{dir}", dir, -1)
 
-	return nil
+	return repoURL + "/" + format
 }
@@ -146,3 +146,35 @@ func TestSetTemplatesInvalid(t *testing.T) {
 		t.Errorf("Expected error, got nil")
 	}
 }
+
+func TestSourceURL(t *testing.T) {
+	checks := []struct {
+		provider string
+		dir      string
+		file     string
+		ref      string
+		path     string
+		out      string
+	}{
+		{"github", "", "", "", "", "https://github.com/rsc/quote/tree/master"},
+		{"github", "", "", "v1.5.2", "", "https://github.com/rsc/quote/tree/v1.5.2"},
+		{"github", "", "", "v1.5.2", "buggy", "https://github.com/rsc/quote/tree/v1.5.2/buggy"},
+		{"gitea", "", "", "v1.5.2", "buggy", "https://github.com/rsc/quote/src/v1.5.2/buggy"},
+		{"", "src/main{/dir}", "{file}", "v1.5.2", "buggy", "https://github.com/rsc/quote/src/main/buggy"},
+		{"", "", "", "v1.5.2", "buggy", "https://github.com/rsc/quote"},
+	}
+
+	for _, c := range checks {
+		var v Vcs
+		if c.provider != "" {
+			v.SetProvider(c.provider)
+		} else {
+			v.SetTemplates(c.dir, c.file)
+		}
+
+		if res := v.sourceURL("https://github.com/rsc/quote", c.ref, c.path); res != c.out {
+			t.Errorf("Mismatch in Vcs.sourceURL(%v, %v) for %#v, expected %#v, got %#v",
+				c.ref, c.path, c, c.out, res)
+		}
+	}
+}